@@ -0,0 +1,71 @@
+package adapter
+
+import (
+	"container/list"
+	"regexp"
+	"sync"
+)
+
+// contentCache is an LRU cache of repository contents by owner/repo/path/ref
+type contentCache struct {
+	entries map[string]*list.Element
+	// order has the most recently used entry at the front
+	order *list.List
+	size  int
+	mu    sync.Mutex
+}
+
+type cachedContent struct {
+	key     string
+	content string
+	// etag revalidates the content of refs other than commit SHAs
+	etag string
+}
+
+// newContentCache returns a cache holding up to size contents. Zero disables caching.
+func newContentCache(size int) *contentCache {
+	return &contentCache{entries: make(map[string]*list.Element), order: list.New(), size: size}
+}
+
+func (c *contentCache) get(key string) (cachedContent, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		return cachedContent{}, false
+	}
+	c.order.MoveToFront(e)
+
+	return e.Value.(cachedContent), true
+}
+
+func (c *contentCache) add(key, content, etag string) {
+	if c.size <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry := cachedContent{key: key, content: content, etag: etag}
+	if e, ok := c.entries[key]; ok {
+		e.Value = entry
+		c.order.MoveToFront(e)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(entry)
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(cachedContent).key)
+	}
+}
+
+var commitSHAPattern = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
+// isCommitSHA reports whether ref is a full commit SHA, whose contents never change
+func isCommitSHA(ref string) bool {
+	return commitSHAPattern.MatchString(ref)
+}
@@ -0,0 +1,112 @@
+package adapter
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/google/go-github/v52/github"
+	"github.com/karahiyo/actions-job/tracing"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/api/googleapi"
+)
+
+// Kinds of APIError
+var (
+	ErrRateLimited      = errors.New("rate limited")
+	ErrConflict         = errors.New("conflict")
+	ErrUnavailable      = errors.New("unavailable")
+	ErrPermissionDenied = errors.New("permission denied")
+	ErrNotFound         = errors.New("not found")
+	ErrInvalid          = errors.New("invalid request")
+)
+
+// APIError is a classified error of a Cloud Run or GitHub API call.
+// errors.Is reports whether it is of a kind, e.g. errors.Is(err, ErrConflict).
+type APIError struct {
+	Err  error
+	Kind error
+	// StatusCode is zero for network errors
+	StatusCode int
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Kind, e.Err)
+}
+
+func (e *APIError) Unwrap() []error {
+	return []error{e.Err, e.Kind}
+}
+
+// IsRetryable reports whether err is a transient API error which may succeed on retry
+func IsRetryable(err error) bool {
+	return errors.Is(err, ErrRateLimited) || errors.Is(err, ErrConflict) || errors.Is(err, ErrUnavailable)
+}
+
+// IsPermanent reports whether err is an API error which fails again on retry
+func IsPermanent(err error) bool {
+	var apiErr *APIError
+	return errors.Is(err, ErrJobNotFound) || errors.As(err, &apiErr) && !IsRetryable(err)
+}
+
+// classifyError wraps err in an APIError if it is caused by a Cloud Run or GitHub API response or a network failure
+func classifyError(err error) error {
+	var apiErr *APIError
+	if err == nil || errors.As(err, &apiErr) {
+		return err
+	}
+
+	var (
+		gErr       *googleapi.Error
+		rateErr    *github.RateLimitError
+		abuseErr   *github.AbuseRateLimitError
+		ghErr      *github.ErrorResponse
+		netErr     net.Error
+		statusCode int
+	)
+	switch {
+	case errors.As(err, new(*RateLimitError)):
+		return &APIError{Err: err, Kind: ErrRateLimited}
+	case errors.As(err, &rateErr):
+		// defer until the reset like the reserve of rateLimitTransport
+		limitErr := &RateLimitError{Resource: rateLimitResourceCore, Remaining: rateErr.Rate.Remaining, Reset: rateErr.Rate.Reset.Time}
+		return &APIError{Err: fmt.Errorf("%w: %w", limitErr, err), Kind: ErrRateLimited, StatusCode: rateErr.Response.StatusCode}
+	case errors.As(err, &abuseErr):
+		return &APIError{Err: err, Kind: ErrRateLimited, StatusCode: abuseErr.Response.StatusCode}
+	case errors.As(err, &gErr):
+		statusCode = gErr.Code
+	case errors.As(err, &ghErr) && ghErr.Response != nil:
+		statusCode = ghErr.Response.StatusCode
+	case errors.As(err, &netErr):
+		return &APIError{Err: err, Kind: ErrUnavailable}
+	default:
+		return err
+	}
+
+	var kind error
+	switch {
+	case statusCode == http.StatusTooManyRequests:
+		kind = ErrRateLimited
+	case statusCode == http.StatusConflict:
+		kind = ErrConflict
+	case statusCode >= http.StatusInternalServerError:
+		kind = ErrUnavailable
+	case statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden:
+		kind = ErrPermissionDenied
+	case statusCode == http.StatusNotFound:
+		kind = ErrNotFound
+	case statusCode >= http.StatusBadRequest:
+		kind = ErrInvalid
+	default:
+		return err
+	}
+
+	return &APIError{Err: err, Kind: kind, StatusCode: statusCode}
+}
+
+// endSpan classifies the error returned by an API call and ends its span
+func endSpan(span trace.Span, err *error) {
+	*err = classifyError(*err)
+	tracing.End(span, *err)
+}
@@ -0,0 +1,52 @@
+package adapter
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/google/go-github/v52/github"
+	"google.golang.org/api/googleapi"
+)
+
+func TestClassifyError(t *testing.T) {
+	ghResponse := func(code int) *http.Response {
+		return &http.Response{StatusCode: code, Request: &http.Request{}}
+	}
+
+	tests := []struct {
+		name          string
+		err           error
+		wantKind      error
+		wantRetryable bool
+	}{
+		{name: "cloud run quota", err: &googleapi.Error{Code: 429}, wantKind: ErrRateLimited, wantRetryable: true},
+		{name: "cloud run conflict", err: &googleapi.Error{Code: 409}, wantKind: ErrConflict, wantRetryable: true},
+		{name: "cloud run 503", err: &googleapi.Error{Code: 503}, wantKind: ErrUnavailable, wantRetryable: true},
+		{name: "cloud run permission denied", err: &googleapi.Error{Code: 403}, wantKind: ErrPermissionDenied},
+		{name: "github rate limit", err: &github.RateLimitError{Response: ghResponse(403)}, wantKind: ErrRateLimited, wantRetryable: true},
+		{name: "github not found", err: &github.ErrorResponse{Response: ghResponse(404)}, wantKind: ErrNotFound},
+		{name: "github validation failed", err: &github.ErrorResponse{Response: ghResponse(422)}, wantKind: ErrInvalid},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := classifyError(fmt.Errorf("failed to call api: %w", tt.err))
+
+			if !errors.Is(err, tt.wantKind) {
+				t.Errorf("classifyError() = %v, want kind %v", err, tt.wantKind)
+			}
+			if IsRetryable(err) != tt.wantRetryable {
+				t.Errorf("IsRetryable() = %v, want %v", IsRetryable(err), tt.wantRetryable)
+			}
+			if IsPermanent(err) == tt.wantRetryable {
+				t.Errorf("IsPermanent() = %v, want %v", IsPermanent(err), !tt.wantRetryable)
+			}
+		})
+	}
+
+	if err := errors.New("unknown"); classifyError(err) != err {
+		t.Error("classifyError() modified an error not caused by an api call")
+	}
+}
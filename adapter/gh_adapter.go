@@ -4,23 +4,41 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"net/url"
+	"strings"
 
 	"github.com/bradleyfalzon/ghinstallation/v2"
 	"github.com/google/go-github/v52/github"
 	"github.com/karahiyo/actions-job/config"
+	"github.com/karahiyo/actions-job/metrics"
+	"github.com/karahiyo/actions-job/tracing"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type GitHubAdapter interface {
 	DownloadContent(ctx context.Context, owner, repo, path, ref string) (string, error)
+	VerifyCredentials(ctx context.Context) error
+	ListRunners(ctx context.Context, owner, repo string) ([]*github.Runner, error)
+	RemoveRunner(ctx context.Context, owner, repo string, runnerID int64) error
+	CreateRegistrationToken(ctx context.Context, owner, repo string) (string, error)
+	ListInstallationRepos(ctx context.Context) ([]*github.Repository, error)
+	ListAppInstallations(ctx context.Context) ([]*github.Installation, error)
+	ListQueuedWorkflowJobs(ctx context.Context, owner, repo string) ([]*github.WorkflowJob, error)
+	CreateCheckRun(ctx context.Context, owner, repo string, opts github.CreateCheckRunOptions) (*github.CheckRun, error)
+	GetHookRanges(ctx context.Context) ([]string, error)
 }
 
 type gitHubAdapter struct {
 	ghClient *github.Client
+	// appClient authenticates as the GitHub App with a JWT, for the endpoints not available to installations
+	appClient *github.Client
+	itr       *ghinstallation.Transport
+	manifests *contentCache
 }
 
 func NewGitHubAdapter(conf config.GitHubAppConfig) (GitHubAdapter, error) {
 	// Shared transport to reuse TCP connections
-	tr := http.DefaultTransport
+	tr := tracing.Transport(http.DefaultTransport)
 
 	// Wrap the shared transport for use with GitHub App authentication.
 	itr, err := ghinstallation.New(
@@ -33,17 +51,70 @@ func NewGitHubAdapter(conf config.GitHubAppConfig) (GitHubAdapter, error) {
 		return nil, fmt.Errorf("failed to ghinstallation.New: %w", err)
 	}
 
+	itr.BaseURL = conf.APIBaseURL()
+
+	atr, err := ghinstallation.NewAppsTransport(tr, conf.AppID, []byte(conf.PrivateKey))
+	if err != nil {
+		return nil, fmt.Errorf("failed to ghinstallation.NewAppsTransport: %w", err)
+	}
+	atr.BaseURL = conf.APIBaseURL()
+
 	// Use installation transport
-	c := github.NewClient(&http.Client{
-		Transport: itr,
+	c, err := newGitHubClient(conf, &http.Client{
+		Transport: newRateLimitTransport(itr, conf.RateLimitReserve),
 		Timeout:   conf.RequestTimeout,
 	})
+	if err != nil {
+		return nil, err
+	}
+	appClient, err := newGitHubClient(conf, &http.Client{
+		Transport: newRateLimitTransport(atr, conf.RateLimitReserve),
+		Timeout:   conf.RequestTimeout,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &gitHubAdapter{ghClient: c, appClient: appClient, itr: itr, manifests: newContentCache(conf.ManifestCacheSize)}, nil
+}
+
+// newGitHubClient returns the client of GitHub.com, or of GitHub Enterprise Server if GitHubAppConfig.APIURL is set
+func newGitHubClient(conf config.GitHubAppConfig, httpClient *http.Client) (*github.Client, error) {
+	if conf.APIURL == "" {
+		return github.NewClient(httpClient), nil
+	}
+
+	uploadURL := strings.TrimSuffix(conf.APIBaseURL(), "/api/v3") + "/api/uploads/"
+	c, err := github.NewEnterpriseClient(conf.APIBaseURL()+"/", uploadURL, httpClient)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize github enterprise client: api_url=%s, %w", conf.APIURL, err)
+	}
 
-	return &gitHubAdapter{ghClient: c}, nil
+	return c, nil
 }
 
-func (c *gitHubAdapter) DownloadContent(ctx context.Context, owner, repo, path, ref string) (string, error) {
-	content, _, _, err := c.ghClient.Repositories.GetContents(ctx, owner, repo, path, &github.RepositoryContentGetOptions{Ref: ref})
+func (c *gitHubAdapter) DownloadContent(ctx context.Context, owner, repo, path, ref string) (_ string, err error) {
+	ctx, span := tracing.Start(ctx, "GitHubAdapter.DownloadContent",
+		tracing.AttrRepository.String(owner+"/"+repo),
+		attribute.String("github.path", path),
+		attribute.String("github.ref", ref),
+	)
+	defer endSpan(span, &err)
+
+	key := owner + "/" + repo + "/" + path + "@" + ref
+	cached, ok := c.manifests.get(key)
+	// contents at a commit never change
+	if ok && isCommitSHA(ref) {
+		metrics.IncManifestCache(metrics.CacheHit)
+		return cached.content, nil
+	}
+
+	content, resp, err := c.getContents(ctx, owner, repo, path, ref, cached.etag)
+	if ok && resp != nil && resp.StatusCode == http.StatusNotModified {
+		metrics.IncManifestCache(metrics.CacheNotModified)
+		return cached.content, nil
+	}
+	metrics.IncManifestCache(metrics.CacheMiss)
 	if err != nil {
 		return "", fmt.Errorf("failed to download github repository content: owner=%s, repo=%s, path=%s, ref=%s, err=%w", owner, repo, path, ref, err)
 	}
@@ -52,6 +123,216 @@ func (c *gitHubAdapter) DownloadContent(ctx context.Context, owner, repo, path,
 	if err != nil {
 		return "", fmt.Errorf("failed to decode content body: %w", err)
 	}
+	c.manifests.add(key, decoded, resp.Header.Get("ETag"))
 
 	return decoded, nil
 }
+
+// getContents gets the file content like Repositories.GetContents, with If-None-Match if etag is not empty.
+// Conditional requests answered with 304 Not Modified do not count against the rate limit.
+func (c *gitHubAdapter) getContents(ctx context.Context, owner, repo, path, ref, etag string) (*github.RepositoryContent, *github.Response, error) {
+	escapedPath := (&url.URL{Path: strings.TrimSuffix(path, "/")}).String()
+	u := fmt.Sprintf("repos/%s/%s/contents/%s", owner, repo, escapedPath)
+	if ref != "" {
+		u += "?ref=" + url.QueryEscape(ref)
+	}
+
+	req, err := c.ghClient.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+
+	content := new(github.RepositoryContent)
+	resp, err := c.ghClient.Do(ctx, req, content)
+	if err != nil {
+		return nil, resp, err
+	}
+
+	return content, resp, nil
+}
+
+// VerifyCredentials checks that the GitHub App private key can mint an installation token
+func (c *gitHubAdapter) VerifyCredentials(ctx context.Context) error {
+	if _, err := c.itr.Token(ctx); err != nil {
+		return fmt.Errorf("failed to mint installation token: %w", err)
+	}
+
+	return nil
+}
+
+// ListRunners lists the self-hosted runners registered to the repository
+func (c *gitHubAdapter) ListRunners(ctx context.Context, owner, repo string) (_ []*github.Runner, err error) {
+	ctx, span := tracing.Start(ctx, "GitHubAdapter.ListRunners", tracing.AttrRepository.String(owner+"/"+repo))
+	defer endSpan(span, &err)
+
+	var runners []*github.Runner
+	opts := &github.ListOptions{PerPage: 100}
+	for {
+		res, resp, err := c.ghClient.Actions.ListRunners(ctx, owner, repo, opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list runners: owner=%s, repo=%s, %w", owner, repo, err)
+		}
+		runners = append(runners, res.Runners...)
+
+		if resp.NextPage == 0 {
+			return runners, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+// RemoveRunner deregisters the self-hosted runner from the repository
+func (c *gitHubAdapter) RemoveRunner(ctx context.Context, owner, repo string, runnerID int64) (err error) {
+	ctx, span := tracing.Start(ctx, "GitHubAdapter.RemoveRunner",
+		tracing.AttrRepository.String(owner+"/"+repo),
+		attribute.Int64("github.runner_id", runnerID),
+	)
+	defer endSpan(span, &err)
+
+	if _, err := c.ghClient.Actions.RemoveRunner(ctx, owner, repo, runnerID); err != nil {
+		return fmt.Errorf("failed to remove runner: owner=%s, repo=%s, id=%d, %w", owner, repo, runnerID, err)
+	}
+
+	return nil
+}
+
+// CreateRegistrationToken creates a token to register a self-hosted runner to the repository, valid for an hour
+func (c *gitHubAdapter) CreateRegistrationToken(ctx context.Context, owner, repo string) (_ string, err error) {
+	ctx, span := tracing.Start(ctx, "GitHubAdapter.CreateRegistrationToken", tracing.AttrRepository.String(owner+"/"+repo))
+	defer endSpan(span, &err)
+
+	token, _, err := c.ghClient.Actions.CreateRegistrationToken(ctx, owner, repo)
+	if err != nil {
+		return "", fmt.Errorf("failed to create registration token: owner=%s, repo=%s, %w", owner, repo, err)
+	}
+
+	return token.GetToken(), nil
+}
+
+// ListAppInstallations lists the installations of the GitHub App, authenticated as the App
+func (c *gitHubAdapter) ListAppInstallations(ctx context.Context) (_ []*github.Installation, err error) {
+	ctx, span := tracing.Start(ctx, "GitHubAdapter.ListAppInstallations")
+	defer endSpan(span, &err)
+
+	var installations []*github.Installation
+	opts := &github.ListOptions{PerPage: 100}
+	for {
+		res, resp, err := c.appClient.Apps.ListInstallations(ctx, opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list app installations: %w", err)
+		}
+		installations = append(installations, res...)
+
+		if resp.NextPage == 0 {
+			return installations, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+// ListInstallationRepos lists the repositories accessible to the GitHub App installation
+func (c *gitHubAdapter) ListInstallationRepos(ctx context.Context) (_ []*github.Repository, err error) {
+	ctx, span := tracing.Start(ctx, "GitHubAdapter.ListInstallationRepos")
+	defer endSpan(span, &err)
+
+	var repos []*github.Repository
+	opts := &github.ListOptions{PerPage: 100}
+	for {
+		res, resp, err := c.ghClient.Apps.ListRepos(ctx, opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list installation repositories: %w", err)
+		}
+		repos = append(repos, res.Repositories...)
+
+		if resp.NextPage == 0 {
+			return repos, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+// ListQueuedWorkflowJobs lists the queued workflow jobs of the queued and in progress workflow runs of the repository
+func (c *gitHubAdapter) ListQueuedWorkflowJobs(ctx context.Context, owner, repo string) (_ []*github.WorkflowJob, err error) {
+	ctx, span := tracing.Start(ctx, "GitHubAdapter.ListQueuedWorkflowJobs", tracing.AttrRepository.String(owner+"/"+repo))
+	defer endSpan(span, &err)
+
+	var jobs []*github.WorkflowJob
+	// workflow runs are in progress once any of their jobs started
+	for _, status := range []string{"queued", "in_progress"} {
+		opts := &github.ListWorkflowRunsOptions{Status: status, ListOptions: github.ListOptions{PerPage: 100}}
+		for {
+			runs, resp, err := c.ghClient.Actions.ListRepositoryWorkflowRuns(ctx, owner, repo, opts)
+			if err != nil {
+				return nil, fmt.Errorf("failed to list workflow runs: owner=%s, repo=%s, status=%s, %w", owner, repo, status, err)
+			}
+
+			for _, run := range runs.WorkflowRuns {
+				runJobs, err := c.listWorkflowJobs(ctx, owner, repo, run.GetID())
+				if err != nil {
+					return nil, err
+				}
+				for _, job := range runJobs {
+					if job.GetStatus() == "queued" {
+						jobs = append(jobs, job)
+					}
+				}
+			}
+
+			if resp.NextPage == 0 {
+				break
+			}
+			opts.Page = resp.NextPage
+		}
+	}
+
+	return jobs, nil
+}
+
+func (c *gitHubAdapter) listWorkflowJobs(ctx context.Context, owner, repo string, runID int64) ([]*github.WorkflowJob, error) {
+	var jobs []*github.WorkflowJob
+	opts := &github.ListWorkflowJobsOptions{Filter: "latest", ListOptions: github.ListOptions{PerPage: 100}}
+	for {
+		res, resp, err := c.ghClient.Actions.ListWorkflowJobs(ctx, owner, repo, runID, opts)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list workflow jobs: owner=%s, repo=%s, run_id=%d, %w", owner, repo, runID, err)
+		}
+		jobs = append(jobs, res.Jobs...)
+
+		if resp.NextPage == 0 {
+			return jobs, nil
+		}
+		opts.Page = resp.NextPage
+	}
+}
+
+// CreateCheckRun creates a check run on the commit. It requires the checks:write permission of the GitHub App.
+func (c *gitHubAdapter) CreateCheckRun(ctx context.Context, owner, repo string, opts github.CreateCheckRunOptions) (_ *github.CheckRun, err error) {
+	ctx, span := tracing.Start(ctx, "GitHubAdapter.CreateCheckRun",
+		tracing.AttrRepository.String(owner+"/"+repo),
+		attribute.String("github.head_sha", opts.HeadSHA),
+	)
+	defer endSpan(span, &err)
+
+	checkRun, _, err := c.ghClient.Checks.CreateCheckRun(ctx, owner, repo, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create check run: owner=%s, repo=%s, head_sha=%s, %w", owner, repo, opts.HeadSHA, err)
+	}
+
+	return checkRun, nil
+}
+
+// GetHookRanges returns the IP ranges in CIDR notation that GitHub sends webhooks from, published by the meta API
+func (c *gitHubAdapter) GetHookRanges(ctx context.Context) (_ []string, err error) {
+	ctx, span := tracing.Start(ctx, "GitHubAdapter.GetHookRanges")
+	defer endSpan(span, &err)
+
+	meta, _, err := c.ghClient.APIMeta(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get github meta: %w", err)
+	}
+
+	return meta.Hooks, nil
+}
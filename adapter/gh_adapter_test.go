@@ -0,0 +1,107 @@
+package adapter
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v52/github"
+	"github.com/karahiyo/actions-job/config"
+)
+
+func newTestGitHubAdapter(t *testing.T, handler http.Handler) *gitHubAdapter {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+
+	client := github.NewClient(nil)
+	client.BaseURL, _ = url.Parse(srv.URL + "/")
+
+	return &gitHubAdapter{ghClient: client, manifests: newContentCache(10)}
+}
+
+func TestGitHubAdapter_DownloadContentCache(t *testing.T) {
+	requests := make(map[string]int)
+	a := newTestGitHubAdapter(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ref := r.URL.Query().Get("ref")
+		requests[ref]++
+
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		fmt.Fprintf(w, `{"type": "file", "encoding": "base64", "content": %q}`, base64.StdEncoding.EncodeToString([]byte("manifest@"+ref)))
+	}))
+
+	sha := "0123456789abcdef0123456789abcdef01234567"
+	for _, ref := range []string{sha, sha, "main", "main"} {
+		got, err := a.DownloadContent(context.Background(), "org", "repo", "job.yaml", ref)
+		if err != nil {
+			t.Fatalf("DownloadContent() error = %v", err)
+		}
+		if got != "manifest@"+ref {
+			t.Errorf("DownloadContent() = %s, want the manifest at %s", got, ref)
+		}
+	}
+
+	// contents at a commit are not requested again, and branches are revalidated
+	if requests[sha] != 1 || requests["main"] != 2 {
+		t.Errorf("requests = %v, want 1 for the commit and 2 for the branch", requests)
+	}
+}
+
+// TestNewGitHubAdapter_Enterprise runs against a fake GitHub Enterprise Server serving the API under /api/v3
+func TestNewGitHubAdapter_Enterprise(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	privateKey := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v3/app/installations/2/access_tokens", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"token": "ghs_enterprise", "expires_at": %q}`, time.Now().Add(time.Hour).Format(time.RFC3339))
+	})
+	mux.HandleFunc("/api/v3/repos/org/repo/contents/job.yaml", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "token ghs_enterprise" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprintf(w, `{"type": "file", "encoding": "base64", "content": %q}`, base64.StdEncoding.EncodeToString([]byte("manifest")))
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+
+	// the API path is completed from the host
+	a, err := NewGitHubAdapter(config.GitHubAppConfig{
+		PrivateKey:     string(privateKey),
+		RequestTimeout: time.Second,
+		APIURL:         srv.URL,
+		AppID:          1,
+		InstallationID: 2,
+	})
+	if err != nil {
+		t.Fatalf("NewGitHubAdapter() error = %v", err)
+	}
+
+	if err := a.VerifyCredentials(context.Background()); err != nil {
+		t.Errorf("VerifyCredentials() error = %v", err)
+	}
+	got, err := a.DownloadContent(context.Background(), "org", "repo", "job.yaml", "main")
+	if err != nil {
+		t.Fatalf("DownloadContent() error = %v", err)
+	}
+	if got != "manifest" {
+		t.Errorf("DownloadContent() = %s, want manifest", got)
+	}
+}
@@ -4,21 +4,32 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net/http"
+	"strconv"
 	"time"
 
-	"github.com/davecgh/go-spew/spew"
+	"github.com/karahiyo/actions-job/tracing"
 	"github.com/rs/zerolog"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/oauth2/google"
 	"google.golang.org/api/googleapi"
 	"google.golang.org/api/option"
 	"google.golang.org/api/run/v1"
+	htransport "google.golang.org/api/transport/http"
 )
 
 type JobsAdapter interface {
 	GetJob(ctx context.Context, name string) (*run.Job, error)
 	CreateJob(ctx context.Context, job *run.Job) (*run.Job, error)
 	UpdateJob(ctx context.Context, name string, job *run.Job) (*run.Job, error)
-	StartJob(ctx context.Context, name string) (*run.Execution, error)
+	StartJob(ctx context.Context, name string, overrides *run.Overrides) (*run.Execution, error)
 	WaitJobReady(ctx context.Context, name string) (bool, error)
+	CancelExecution(ctx context.Context, name string) error
+	ListJobs(ctx context.Context, labelSelector string) ([]*run.Job, error)
+	DeleteJob(ctx context.Context, name string) error
+	ListExecutions(ctx context.Context) ([]*run.Execution, error)
+	DeleteExecution(ctx context.Context, name string) error
 }
 
 type jobsAdapter struct {
@@ -30,10 +41,7 @@ type jobsAdapter struct {
 var ErrJobNotFound = errors.New("job not found")
 
 func NewJobsAdapter(ctx context.Context, project, region string) (JobsAdapter, error) {
-	var opts []option.ClientOption
-	opts = append(opts, option.WithEndpoint(fmt.Sprintf("https://%s-run.googleapis.com", region)))
-
-	s, err := run.NewService(ctx, opts...)
+	s, err := newRunService(ctx, region)
 	if err != nil {
 		return nil, fmt.Errorf("failed to initialize jobs v1 api jobsAdapter: %w", err)
 	}
@@ -44,34 +52,89 @@ func NewJobsAdapter(ctx context.Context, project, region string) (JobsAdapter, e
 	}, nil
 }
 
-func (a *jobsAdapter) CreateJob(ctx context.Context, job *run.Job) (*run.Job, error) {
+// newRunService creates a client of the regional Cloud Run v1 api
+func newRunService(ctx context.Context, region string) (*run.APIService, error) {
+	// Wrap the authenticated transport so that Cloud Run API calls are traced
+	tr, err := htransport.NewTransport(ctx, tracing.Transport(http.DefaultTransport), option.WithScopes(run.CloudPlatformScope))
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize jobs v1 api transport: %w", err)
+	}
+
+	var opts []option.ClientOption
+	opts = append(opts, option.WithEndpoint(fmt.Sprintf("https://%s-run.googleapis.com", region)))
+	opts = append(opts, option.WithHTTPClient(&http.Client{Transport: tr}))
+
+	return run.NewService(ctx, opts...)
+}
+
+// maxScaleAnnotation is the annotation of the revision template holding the max instances of a Cloud Run service
+const maxScaleAnnotation = "autoscaling.knative.dev/maxScale"
+
+// GetServiceMaxInstances returns the max instances of the Cloud Run service, zero if it is not set
+func GetServiceMaxInstances(ctx context.Context, project, region, name string) (int, error) {
+	s, err := newRunService(ctx, region)
+	if err != nil {
+		return 0, fmt.Errorf("failed to initialize services v1 api client: %w", err)
+	}
+
+	serviceID := fmt.Sprintf("namespaces/%s/services/%s", project, name)
+	svc, err := s.Namespaces.Services.Get(serviceID).Context(ctx).Do()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get service: name=%s, %w", serviceID, err)
+	}
+	if svc.Spec == nil || svc.Spec.Template == nil || svc.Spec.Template.Metadata == nil {
+		return 0, nil
+	}
+
+	maxScale, ok := svc.Spec.Template.Metadata.Annotations[maxScaleAnnotation]
+	if !ok {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(maxScale)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s annotation: name=%s, value=%s, %w", maxScaleAnnotation, serviceID, maxScale, err)
+	}
+
+	return n, nil
+}
+
+func (a *jobsAdapter) CreateJob(ctx context.Context, job *run.Job) (_ *run.Job, err error) {
+	ctx, span := a.startSpan(ctx, "JobsAdapter.CreateJob", job.Metadata.Name)
+	defer endSpan(span, &err)
+
 	parent := fmt.Sprintf("namespaces/%s", a.project)
-	res, err := a.api.Namespaces.Jobs.Create(parent, job).Do()
+	res, err := a.api.Namespaces.Jobs.Create(parent, job).Context(ctx).Do()
 	if err != nil {
-		return nil, fmt.Errorf("failed to CreateJob: job=%s, %w", spew.Sdump(job), err)
+		return nil, fmt.Errorf("failed to CreateJob: name=%s, %w", job.Metadata.Name, err)
 	}
 
 	return res, nil
 }
 
-func (a *jobsAdapter) UpdateJob(ctx context.Context, name string, job *run.Job) (*run.Job, error) {
+func (a *jobsAdapter) UpdateJob(ctx context.Context, name string, job *run.Job) (_ *run.Job, err error) {
+	ctx, span := a.startSpan(ctx, "JobsAdapter.UpdateJob", name)
+	defer endSpan(span, &err)
+
 	jobID := fmt.Sprintf("namespaces/%s/jobs/%s", a.project, name)
-	res, err := a.api.Namespaces.Jobs.ReplaceJob(jobID, job).Do()
+	res, err := a.api.Namespaces.Jobs.ReplaceJob(jobID, job).Context(ctx).Do()
 	if err != nil {
 		var gErr *googleapi.Error
 		if errors.As(err, &gErr) && gErr.Code == 404 {
 			return nil, fmt.Errorf("job dose not found: name=%s, %w", jobID, ErrJobNotFound)
 		}
 
-		return nil, fmt.Errorf("failed to ReplaceJob: name=%s, job=%s, %w", name, spew.Sdump(job), err)
+		return nil, fmt.Errorf("failed to ReplaceJob: name=%s, %w", name, err)
 	}
 
 	return res, nil
 }
 
-func (a *jobsAdapter) GetJob(ctx context.Context, name string) (*run.Job, error) {
+func (a *jobsAdapter) GetJob(ctx context.Context, name string) (_ *run.Job, err error) {
+	ctx, span := a.startSpan(ctx, "JobsAdapter.GetJob", name)
+	defer endSpan(span, &err)
+
 	jobID := fmt.Sprintf("namespaces/%s/jobs/%s", a.project, name)
-	job, err := a.api.Namespaces.Jobs.Get(jobID).Do()
+	job, err := a.api.Namespaces.Jobs.Get(jobID).Context(ctx).Do()
 	if err != nil {
 		var gErr *googleapi.Error
 		if errors.As(err, &gErr) && gErr.Code == 404 {
@@ -84,28 +147,161 @@ func (a *jobsAdapter) GetJob(ctx context.Context, name string) (*run.Job, error)
 	return job, nil
 }
 
-func (a *jobsAdapter) StartJob(ctx context.Context, name string) (*run.Execution, error) {
+// StartJob starts an execution of the job, overriding the task count and the container envs of the job spec if overrides is not nil
+func (a *jobsAdapter) StartJob(ctx context.Context, name string, overrides *run.Overrides) (_ *run.Execution, err error) {
+	ctx, span := a.startSpan(ctx, "JobsAdapter.StartJob", name)
+	defer endSpan(span, &err)
+
 	jobID := fmt.Sprintf("namespaces/%s/jobs/%s", a.project, name)
+	// the service account requires the run.jobs.runWithOverrides permission to run the job with overrides
 	runJobRequest := &run.RunJobRequest{
-		// FIXME: Using the 'namespaces.jobs.run' api with a container override option will result in a 403 permission denied error, even through a service account that has the run.jobs.runWithOverrides permission.
-		Overrides: nil,
+		Overrides: overrides,
 	}
 
-	execution, err := a.api.Namespaces.Jobs.Run(jobID, runJobRequest).Do()
+	execution, err := a.api.Namespaces.Jobs.Run(jobID, runJobRequest).Context(ctx).Do()
 	if err != nil {
 		var gErr *googleapi.Error
 		if errors.As(err, &gErr) && gErr.Code == 404 {
 			return nil, fmt.Errorf("job dose not found: name=%s, %w", jobID, ErrJobNotFound)
 		}
 
-		return nil, fmt.Errorf("failed to start job: name=%s, req=%s, res=%s, %w", name, spew.Sdump(runJobRequest), spew.Sdump(execution), err)
+		return nil, fmt.Errorf("failed to start job: name=%s, %w", name, err)
 	}
 
 	return execution, nil
 }
 
+func (a *jobsAdapter) startSpan(ctx context.Context, name, jobName string) (context.Context, trace.Span) {
+	return tracing.Start(ctx, name,
+		tracing.AttrProject.String(a.project),
+		tracing.AttrRegion.String(a.region),
+		tracing.AttrJobName.String(jobName),
+	)
+}
+
+func (a *jobsAdapter) CancelExecution(ctx context.Context, name string) (err error) {
+	ctx, span := tracing.Start(ctx, "JobsAdapter.CancelExecution",
+		tracing.AttrProject.String(a.project),
+		tracing.AttrRegion.String(a.region),
+		attribute.String("cloud_run.execution", name),
+	)
+	defer endSpan(span, &err)
+
+	executionID := fmt.Sprintf("namespaces/%s/executions/%s", a.project, name)
+	if _, err := a.api.Namespaces.Executions.Cancel(executionID, &run.CancelExecutionRequest{}).Context(ctx).Do(); err != nil {
+		var gErr *googleapi.Error
+		if errors.As(err, &gErr) && gErr.Code == 404 {
+			return fmt.Errorf("execution dose not found: name=%s, %w", executionID, ErrJobNotFound)
+		}
+
+		return fmt.Errorf("failed to cancel execution: name=%s, %w", name, err)
+	}
+
+	return nil
+}
+
+// ListJobs lists the jobs matching labelSelector, e.g. "managed-by=actions-job"
+func (a *jobsAdapter) ListJobs(ctx context.Context, labelSelector string) (_ []*run.Job, err error) {
+	ctx, span := a.startSpan(ctx, "JobsAdapter.ListJobs", "")
+	defer endSpan(span, &err)
+
+	parent := fmt.Sprintf("namespaces/%s", a.project)
+	var jobs []*run.Job
+	token := ""
+	for {
+		res, err := a.api.Namespaces.Jobs.List(parent).LabelSelector(labelSelector).Continue(token).Context(ctx).Do()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list jobs: parent=%s, %w", parent, err)
+		}
+		jobs = append(jobs, res.Items...)
+
+		if res.Metadata == nil || res.Metadata.Continue == "" {
+			return jobs, nil
+		}
+		token = res.Metadata.Continue
+	}
+}
+
+func (a *jobsAdapter) DeleteJob(ctx context.Context, name string) (err error) {
+	ctx, span := a.startSpan(ctx, "JobsAdapter.DeleteJob", name)
+	defer endSpan(span, &err)
+
+	jobID := fmt.Sprintf("namespaces/%s/jobs/%s", a.project, name)
+	// delete the executions of the job as well
+	if _, err := a.api.Namespaces.Jobs.Delete(jobID).PropagationPolicy("Background").Context(ctx).Do(); err != nil {
+		var gErr *googleapi.Error
+		if errors.As(err, &gErr) && gErr.Code == 404 {
+			return fmt.Errorf("job dose not found: name=%s, %w", jobID, ErrJobNotFound)
+		}
+
+		return fmt.Errorf("failed to delete job: name=%s, %w", name, err)
+	}
+
+	return nil
+}
+
+// ListExecutions lists the executions of all jobs
+func (a *jobsAdapter) ListExecutions(ctx context.Context) (_ []*run.Execution, err error) {
+	ctx, span := a.startSpan(ctx, "JobsAdapter.ListExecutions", "")
+	defer endSpan(span, &err)
+
+	parent := fmt.Sprintf("namespaces/%s", a.project)
+	var executions []*run.Execution
+	token := ""
+	for {
+		res, err := a.api.Namespaces.Executions.List(parent).Continue(token).Context(ctx).Do()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list executions: parent=%s, %w", parent, err)
+		}
+		executions = append(executions, res.Items...)
+
+		if res.Metadata == nil || res.Metadata.Continue == "" {
+			return executions, nil
+		}
+		token = res.Metadata.Continue
+	}
+}
+
+func (a *jobsAdapter) DeleteExecution(ctx context.Context, name string) (err error) {
+	ctx, span := tracing.Start(ctx, "JobsAdapter.DeleteExecution",
+		tracing.AttrProject.String(a.project),
+		tracing.AttrRegion.String(a.region),
+		attribute.String("cloud_run.execution", name),
+	)
+	defer endSpan(span, &err)
+
+	executionID := fmt.Sprintf("namespaces/%s/executions/%s", a.project, name)
+	if _, err := a.api.Namespaces.Executions.Delete(executionID).Context(ctx).Do(); err != nil {
+		var gErr *googleapi.Error
+		if errors.As(err, &gErr) && gErr.Code == 404 {
+			return fmt.Errorf("execution dose not found: name=%s, %w", executionID, ErrJobNotFound)
+		}
+
+		return fmt.Errorf("failed to delete execution: name=%s, %w", name, err)
+	}
+
+	return nil
+}
+
+// VerifyGoogleCredentials checks that the default Google credentials can issue a token for the Cloud Run API
+func VerifyGoogleCredentials(ctx context.Context) error {
+	creds, err := google.FindDefaultCredentials(ctx, run.CloudPlatformScope)
+	if err != nil {
+		return fmt.Errorf("failed to find default credentials: %w", err)
+	}
+
+	if _, err := creds.TokenSource.Token(); err != nil {
+		return fmt.Errorf("failed to get token from default credentials: %w", err)
+	}
+
+	return nil
+}
+
 // WaitJobReady Wait until the job's Ready status condition is True
-func (a *jobsAdapter) WaitJobReady(ctx context.Context, name string) (bool, error) {
+func (a *jobsAdapter) WaitJobReady(ctx context.Context, name string) (_ bool, err error) {
+	ctx, span := a.startSpan(ctx, "JobsAdapter.WaitJobReady", name)
+	defer endSpan(span, &err)
+
 	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
 
@@ -6,7 +6,10 @@ import (
 	"io"
 	"net/http"
 	"strings"
+	"sync"
 	"time"
+
+	"github.com/karahiyo/actions-job/tracing"
 )
 
 const endpoint = "http://metadata.google.internal"
@@ -16,7 +19,31 @@ type Metadata struct {
 	Region    string
 }
 
+// cachedMetadata is the instance metadata, which does not change for the life of the process
+var cachedMetadata struct {
+	meta *Metadata
+	mu   sync.Mutex
+}
+
+// GetInstanceMetadata returns the project and region of the instance from the metadata server.
+// The result is cached for the life of the process once it succeeds.
 func GetInstanceMetadata(ctx context.Context) (*Metadata, error) {
+	cachedMetadata.mu.Lock()
+	defer cachedMetadata.mu.Unlock()
+
+	if cachedMetadata.meta == nil {
+		meta, err := getInstanceMetadata(ctx)
+		if err != nil {
+			return nil, err
+		}
+		cachedMetadata.meta = meta
+	}
+
+	meta := *cachedMetadata.meta
+	return &meta, nil
+}
+
+func getInstanceMetadata(ctx context.Context) (*Metadata, error) {
 	meta := new(Metadata)
 	metadataClient, err := NewMetadataClient()
 	if err != nil {
@@ -40,7 +67,8 @@ type MetadataClient struct {
 
 func NewMetadataClient() (*MetadataClient, error) {
 	cli := &http.Client{
-		Timeout: 1 * time.Second,
+		Transport: tracing.Transport(http.DefaultTransport),
+		Timeout:   1 * time.Second,
 	}
 
 	return &MetadataClient{cli: cli}, nil
@@ -55,10 +83,14 @@ func (m *MetadataClient) getProjectID(ctx context.Context) (string, error) {
 
 	resp, err := m.cli.Do(req)
 	if err != nil {
-		return "", fmt.Errorf("failed to get region: %w", err)
+		return "", fmt.Errorf("failed to get project id: %w", err)
 	}
 	defer resp.Body.Close() // nolint:errcheck
 
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status code from metadata server: code=%d", resp.StatusCode)
+	}
+
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return "", fmt.Errorf("failed to read response body: %w", err)
@@ -80,6 +112,10 @@ func (m *MetadataClient) getRegion(ctx context.Context) (string, error) {
 	}
 	defer resp.Body.Close() // nolint:errcheck
 
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status code from metadata server: code=%d", resp.StatusCode)
+	}
+
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return "", fmt.Errorf("failed to read response body: %w", err)
@@ -0,0 +1,90 @@
+package adapter
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/karahiyo/actions-job/metrics"
+)
+
+// rateLimitResourceCore is the rate limit resource of the REST API calls made by the controller
+const rateLimitResourceCore = "core"
+
+// RateLimitError is returned when the GitHub API rate limit is exhausted, or its remaining quota is within the reserve.
+// Callers can defer the work until Reset.
+type RateLimitError struct {
+	Reset     time.Time
+	Resource  string
+	Remaining int
+}
+
+func (e *RateLimitError) Error() string {
+	return fmt.Sprintf("github api rate limit: resource=%s, remaining=%d, reset=%s", e.Resource, e.Remaining, e.Reset.Format(time.RFC3339))
+}
+
+// rateLimitTransport tracks the rate limit from the response headers, and fails the requests without sending them
+// while the remaining quota is within the reserve
+type rateLimitTransport struct {
+	base    http.RoundTripper
+	reserve int
+
+	remaining int
+	reset     time.Time
+	mu        sync.Mutex
+}
+
+func newRateLimitTransport(base http.RoundTripper, reserve int) *rateLimitTransport {
+	return &rateLimitTransport{base: base, reserve: reserve, remaining: -1}
+}
+
+func (t *rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.check(time.Now()); err != nil {
+		return nil, err
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	t.update(resp.Header)
+
+	return resp, nil
+}
+
+func (t *rateLimitTransport) check(now time.Time) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.remaining < 0 || t.remaining > t.reserve || !now.Before(t.reset) {
+		return nil
+	}
+
+	return &RateLimitError{Resource: rateLimitResourceCore, Remaining: t.remaining, Reset: t.reset}
+}
+
+func (t *rateLimitTransport) update(header http.Header) {
+	resource := header.Get("X-RateLimit-Resource")
+	if resource == "" {
+		resource = rateLimitResourceCore
+	}
+
+	limit, err1 := strconv.Atoi(header.Get("X-RateLimit-Limit"))
+	remaining, err2 := strconv.Atoi(header.Get("X-RateLimit-Remaining"))
+	reset, err3 := strconv.ParseInt(header.Get("X-RateLimit-Reset"), 10, 64)
+	if err1 != nil || err2 != nil || err3 != nil {
+		return
+	}
+	metrics.SetGitHubRateLimit(resource, limit, remaining, reset)
+
+	if resource != rateLimitResourceCore {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.remaining = remaining
+	t.reset = time.Unix(reset, 0)
+}
@@ -0,0 +1,60 @@
+package adapter
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestRateLimitTransport(t *testing.T) {
+	reset := time.Now().Add(time.Hour).Truncate(time.Second)
+	remaining := 100
+	calls := 0
+	transport := newRateLimitTransport(roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		calls++
+		remaining -= 30
+		header := http.Header{}
+		header.Set("X-RateLimit-Limit", "5000")
+		header.Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+		header.Set("X-RateLimit-Reset", strconv.FormatInt(reset.Unix(), 10))
+		header.Set("X-RateLimit-Resource", "core")
+		return &http.Response{StatusCode: http.StatusOK, Header: header, Request: req}, nil
+	}), 50)
+
+	req, _ := http.NewRequest(http.MethodGet, "https://api.github.com/rate_limit", nil)
+
+	// remaining 70, 40 are sent, and then the transport defers the requests within the reserve
+	for i := 0; i < 2; i++ {
+		if _, err := transport.RoundTrip(req); err != nil {
+			t.Fatalf("RoundTrip() #%d error = %v", i, err)
+		}
+	}
+	_, err := transport.RoundTrip(req)
+
+	var limitErr *RateLimitError
+	if !errors.As(err, &limitErr) {
+		t.Fatalf("RoundTrip() error = %v, want RateLimitError", err)
+	}
+	if limitErr.Remaining != 40 || !limitErr.Reset.Equal(reset) {
+		t.Errorf("RateLimitError = %+v, want remaining 40 and reset %s", limitErr, reset)
+	}
+	if calls != 2 {
+		t.Errorf("calls = %d, want 2", calls)
+	}
+	if !errors.Is(classifyError(err), ErrRateLimited) {
+		t.Errorf("classifyError() = %v, want ErrRateLimited", classifyError(err))
+	}
+
+	// the requests are sent again after the reset
+	if err := transport.check(reset); err != nil {
+		t.Errorf("check() after reset error = %v", err)
+	}
+}
@@ -1,10 +1,17 @@
 package config
 
 import (
+	"context"
 	"fmt"
+	"os"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/caarlos0/env/v8"
+	"github.com/go-playground/validator/v10"
+	"github.com/karahiyo/actions-job/secret"
+	k8syaml "sigs.k8s.io/yaml"
 )
 
 type (
@@ -12,50 +19,497 @@ type (
 		ServerConfig    ServerConfig
 		GCPConfig       GCPConfig
 		GitHubAppConfig GitHubAppConfig
+		TracingConfig   TracingConfig
+		GCConfig        GCConfig
+		// LimitsConfig is only configurable by the config file
+		LimitsConfig LimitsConfig
+		// PoolsConfig is only configurable by the config file
+		PoolsConfig []PoolConfig `validate:"unique=Name,dive"`
+		// SizesConfig is only configurable by the config file. DefaultSizes are used when not configured.
+		SizesConfig map[string]SizeConfig `validate:"dive"`
+		// SourcesConfig is only configurable by the config file
+		SourcesConfig []SourceConfig `validate:"unique=Name,dive"`
 	}
 
 	ServerConfig struct {
-		LogLevel       string        `env:"LOG_LEVEL"               envDefault:"info"`
-		WebhookSecret  string        `env:"WEBHOOK_SECRET,required"`
-		Port           int           `env:"PORT"                    envDefault:"8080"`
-		DefaultTimeout time.Duration `env:"DEFAULT_TIMEOUT"         envDefault:"10s"`
+		LogLevel      string `env:"LOG_LEVEL"      envDefault:"info" validate:"oneof=trace debug info warn error fatal panic"`
+		WebhookSecret string `env:"WEBHOOK_SECRET" redact:"true"     validate:"required_without=WebhookSecrets"`
+		// WebhookSecretSource is a secret reference to resolve WebhookSecret from, e.g. "file:///secrets/webhook-secret".
+		WebhookSecretSource string `env:"WEBHOOK_SECRET_SOURCE"`
+		// WebhookSecrets are additional accepted webhook secrets, e.g. the previous secret while rotating WebhookSecret.
+		WebhookSecrets []string `env:"WEBHOOK_SECRETS" envSeparator:"," redact:"true"`
+		// WebhookIPAllowlist only accepts webhook events from the hooks IP ranges published by the GitHub meta API
+		WebhookIPAllowlist bool `env:"WEBHOOK_IP_ALLOWLIST"`
+		// WebhookIPRanges are the CIDRs accepted in addition to the hooks IP ranges of the GitHub meta API,
+		// e.g. the addresses GitHub Enterprise Server sends webhooks from. It is required with GitHubAppConfig.APIURL.
+		WebhookIPRanges []string `env:"WEBHOOK_IP_RANGES" envSeparator:"," validate:"dive,cidr"`
+		// WebhookIPRangesRefreshInterval is how often the hooks IP ranges are fetched from the GitHub meta API
+		WebhookIPRangesRefreshInterval time.Duration `env:"WEBHOOK_IP_RANGES_REFRESH_INTERVAL" envDefault:"1h" validate:"gt=0"`
+		// TrustedProxies are the CIDRs of the proxies whose X-Forwarded-For header is honoured to get the client IP,
+		// e.g. the load balancer in front of the controller
+		TrustedProxies []string `env:"TRUSTED_PROXIES" envSeparator:"," validate:"dive,cidr"`
+		// DebugToken is the bearer token required by /debug/config. The endpoint is disabled when empty.
+		DebugToken string `env:"DEBUG_TOKEN" redact:"true"`
+		// SpoolDir is where dispatches that did not finish before shutdown are saved, e.g. a mounted volume.
+		// They are dispatched again on the next startup. Unfinished dispatches are only logged when empty.
+		SpoolDir string `env:"SPOOL_DIR"`
+		// ConfigFile is the path to the YAML or JSON config file. It can only be set by the environment variable.
+		ConfigFile string `env:"CONFIG_FILE"`
+		// ConfigReloadInterval is how often ConfigFile is checked for changes. Zero disables reloading.
+		ConfigReloadInterval time.Duration `env:"CONFIG_RELOAD_INTERVAL" envDefault:"10s" validate:"gte=0"`
+		Port                 int           `env:"PORT"                   envDefault:"8080" validate:"min=1,max=65535"`
+		DefaultTimeout       time.Duration `env:"DEFAULT_TIMEOUT"        envDefault:"10s"  validate:"gt=0"`
+		ReadinessCacheTTL    time.Duration `env:"READINESS_CACHE_TTL"    envDefault:"30s"  validate:"gte=0"`
+		// SecretRefreshInterval is how often the secret sources are resolved again to pick up rotated secrets. Zero disables refreshing.
+		SecretRefreshInterval time.Duration `env:"SECRET_REFRESH_INTERVAL" envDefault:"5m" validate:"gte=0"`
+		// ConcurrencyLeaseTTL is how long a dispatched execution counts against the concurrency limits
+		// when the completed event of its workflow job is not received. Set it to the task timeout of the job manifests.
+		ConcurrencyLeaseTTL time.Duration `env:"CONCURRENCY_LEASE_TTL" envDefault:"1h" validate:"gt=0"`
+		// InstallationsRefreshInterval is how often the installations of the GitHub App are listed to refresh the installation registry
+		InstallationsRefreshInterval time.Duration `env:"INSTALLATIONS_REFRESH_INTERVAL" envDefault:"1h" validate:"gt=0"`
+		// ReconcileInterval is how often queued workflow jobs are listed to dispatch the ones whose webhooks were missed.
+		// Zero disables the reconciler.
+		ReconcileInterval time.Duration `env:"RECONCILE_INTERVAL" envDefault:"5m" validate:"gte=0"`
+		// ReconcileMinAge is how long a workflow job stays queued before the reconciler dispatches it,
+		// so that it does not race with the webhook being delivered.
+		ReconcileMinAge time.Duration `env:"RECONCILE_MIN_AGE" envDefault:"2m" validate:"gte=0"`
+		// ReconcileRedispatchAfter is how long a dispatched workflow job may stay queued before the reconciler dispatches it again.
+		ReconcileRedispatchAfter time.Duration `env:"RECONCILE_REDISPATCH_AFTER" envDefault:"30m" validate:"gt=0"`
+		// BatchWindow is how long to wait for other queued workflow jobs with the same labels,
+		// to dispatch them as one execution with a task per workflow job. Zero disables batching.
+		BatchWindow time.Duration `env:"BATCH_WINDOW" envDefault:"0s" validate:"gte=0"`
+		// BatchMaxSize is the maximum number of workflow jobs dispatched as one execution
+		BatchMaxSize int `env:"BATCH_MAX_SIZE" envDefault:"10" validate:"min=1,max=10000"`
+		// RetryBudget is how long to retry a Cloud Run or GitHub API call failing with transient errors,
+		// e.g. rate limits, conflicts and 5xx. Zero disables retries.
+		RetryBudget         time.Duration `env:"RETRY_BUDGET"          envDefault:"5s"    validate:"gte=0"`
+		RetryInitialBackoff time.Duration `env:"RETRY_INITIAL_BACKOFF" envDefault:"200ms" validate:"gt=0"`
+		RetryMaxBackoff     time.Duration `env:"RETRY_MAX_BACKOFF"     envDefault:"2s"    validate:"gtefield=RetryInitialBackoff"`
+		// ShutdownTimeout is how long to wait for in-flight dispatches on SIGTERM.
+		// Cloud Run sends SIGKILL 10 seconds after SIGTERM.
+		ShutdownTimeout time.Duration `env:"SHUTDOWN_TIMEOUT" envDefault:"8s" validate:"gte=0"`
 	}
 
 	GCPConfig struct {
-		Region string `env:"REGION" envDefault:"us-central1"`
+		// ProjectID is used to correlate logs with traces. GetGCPConfig falls back to the project of the metadata server when empty.
+		ProjectID string `env:"GOOGLE_CLOUD_PROJECT"`
+		Region    string `env:"REGION"               envDefault:"us-central1"`
+		// Service is the name of the Cloud Run service of the controller, set by Cloud Run
+		Service string `env:"K_SERVICE"`
 	}
 
 	GitHubAppConfig struct {
-		PrivateKey     string        `env:"GH_APP_PRIVATE_KEY,required"`
-		RequestTimeout time.Duration `env:"GH_REQUEST_TIMEOUT"              envDefault:"1s"`
-		AppID          int64         `env:"GH_APP_ID,required"`
-		InstallationID int64         `env:"GH_APP_INSTALLATION_ID,required"`
+		PrivateKey string `env:"GH_APP_PRIVATE_KEY" redact:"true" validate:"required"`
+		// PrivateKeySource is a secret reference to resolve PrivateKey from, e.g. "sm://projects/my-project/secrets/gh-app-key".
+		PrivateKeySource string        `env:"GH_APP_PRIVATE_KEY_SOURCE"`
+		RequestTimeout   time.Duration `env:"GH_REQUEST_TIMEOUT"        envDefault:"1s" validate:"gt=0"`
+		// APIURL is the REST API URL of GitHub Enterprise Server, e.g. "https://ghes.example.com/api/v3". GitHub.com is used when empty.
+		APIURL string `env:"GH_API_URL" validate:"omitempty,url"`
+		// WebURL is the web URL of GitHub Enterprise Server, e.g. "https://ghes.example.com".
+		// It is derived from APIURL when empty, and GitHub.com is used when both are empty.
+		WebURL string `env:"GH_URL" validate:"omitempty,url"`
+		// RateLimitReserve is the number of remaining API requests at which the controller stops calling GitHub until the rate limit resets,
+		// so that the runners can still register with the installation token.
+		RateLimitReserve int `env:"GH_RATE_LIMIT_RESERVE" envDefault:"50" validate:"gte=0"`
+		// ManifestCacheSize is the number of job manifests cached by repository, path and ref. Zero disables caching.
+		ManifestCacheSize int `env:"GH_MANIFEST_CACHE_SIZE" envDefault:"256" validate:"gte=0"`
+		// ReportFailures posts check runs explaining why no runner was started for workflow jobs.
+		// It requires the checks:write permission, and is skipped for a while when the permission is denied.
+		ReportFailures bool  `env:"GH_REPORT_FAILURES" envDefault:"true"`
+		AppID          int64 `env:"GH_APP_ID,required"`
+		InstallationID int64 `env:"GH_APP_INSTALLATION_ID,required"`
+	}
+
+	GCConfig struct {
+		// Interval is how often offline runners, unused jobs and old executions are deleted. Zero disables it.
+		Interval time.Duration `env:"GC_INTERVAL" envDefault:"1h" validate:"gte=0"`
+		// DryRun only logs what would be deleted
+		DryRun bool `env:"GC_DRY_RUN"`
+		// RunnerNamePattern matches the names of the runners registered by executions, which are named after the executions
+		// and suffixed with the task index for executions with multiple tasks
+		RunnerNamePattern string `env:"GC_RUNNER_NAME_PATTERN" envDefault:"^.+-[a-z0-9]{5}(-[0-9]+)?$"`
+		// RunnerGracePeriod is how long the offline runners of an execution are kept after the execution was created,
+		// since a runner is offline until it connects after registering
+		RunnerGracePeriod time.Duration `env:"GC_RUNNER_GRACE_PERIOD" envDefault:"10m" validate:"gte=0"`
+		// JobRetention is how long a job created by the controller is kept after its latest execution
+		JobRetention time.Duration `env:"GC_JOB_RETENTION" envDefault:"168h" validate:"gt=0"`
+		// ExecutionRetention is how long a completed execution of the jobs created by the controller is kept
+		ExecutionRetention time.Duration `env:"GC_EXECUTION_RETENTION" envDefault:"24h" validate:"gt=0"`
+		// Locations are "project/region" to collect jobs and executions from. The project and region of the instance are used when empty.
+		Locations []string `env:"GC_LOCATIONS" envSeparator:"," validate:"dive,contains=/"`
+	}
+
+	TracingConfig struct {
+		// Exporter is one of "none", "stdout" or "otlp".
+		// The OTLP exporter is configured by the standard OTEL_EXPORTER_OTLP_* environment variables.
+		Exporter    string  `env:"TRACING_EXPORTER"     envDefault:"none"                   validate:"oneof=none stdout otlp"`
+		ServiceName string  `env:"TRACING_SERVICE_NAME" envDefault:"actions-job-controller"`
+		SampleRatio float64 `env:"TRACING_SAMPLE_RATIO" envDefault:"1"                      validate:"gte=0,lte=1"`
 	}
 )
 
-var instance *Config
+// LimitsConfig is the maximum number of concurrent executions. Zero means unlimited.
+// Workflow jobs exceeding the limits wait in a queue until completed workflow jobs free capacity.
+type LimitsConfig struct {
+	// Orgs overrides PerOrg by the owner name
+	Orgs map[string]int `json:"orgs,omitempty"        validate:"dive,gte=0"`
+	// Repos overrides PerRepo by "owner/repo"
+	Repos       map[string]int `json:"repos,omitempty"       validate:"dive,gte=0"`
+	Global      int            `json:"global,omitempty"      validate:"gte=0"`
+	PerOrg      int            `json:"perOrg,omitempty"      validate:"gte=0"`
+	PerRepo     int            `json:"perRepo,omitempty"     validate:"gte=0"`
+	PerManifest int            `json:"perManifest,omitempty" validate:"gte=0"`
+}
+
+// Enabled reports whether any limit is set
+func (l LimitsConfig) Enabled() bool {
+	if l.Global > 0 || l.PerOrg > 0 || l.PerRepo > 0 || l.PerManifest > 0 {
+		return true
+	}
+	for _, limit := range l.Orgs {
+		if limit > 0 {
+			return true
+		}
+	}
+	for _, limit := range l.Repos {
+		if limit > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// SizeConfig is the container resources of the runners selected by the "size=<name>" label
+type SizeConfig struct {
+	CPU    string `json:"cpu"    validate:"required"`
+	Memory string `json:"memory" validate:"required"`
+}
+
+// DefaultSizes are the sizes used when the config file has no sizes section
+var DefaultSizes = map[string]SizeConfig{
+	"small":  {CPU: "1", Memory: "2Gi"},
+	"medium": {CPU: "2", Memory: "8Gi"},
+	"large":  {CPU: "4", Memory: "16Gi"},
+}
+
+// SourceConfig is a webhook received at /github/events/{name}, e.g. a repository or organization webhook,
+// with its own secret. The workflow jobs of the source are dispatched with its GitHub App installation,
+// which is InstallationID of GitHubAppConfig if not set, and AppID and PrivateKey of another App if set.
+type SourceConfig struct {
+	Name string `json:"name" validate:"required,excludesall=/?#"`
+	// Repositories are the "owner/repo" or "owner/*" the source may send workflow jobs of,
+	// so that the holders of its secret cannot dispatch for the other repositories of the installation
+	Repositories  []string `json:"repositories"            validate:"min=1,dive,required,contains=/"`
+	WebhookSecret string   `json:"webhookSecret,omitempty" redact:"true" validate:"required"`
+	// WebhookSecretSource is a secret reference to resolve WebhookSecret from
+	WebhookSecretSource string `json:"webhookSecretSource,omitempty"`
+	AppID               int64  `json:"appID,omitempty"               validate:"required_with=PrivateKey"`
+	InstallationID      int64  `json:"installationID,omitempty"      validate:"required_with=AppID"`
+	PrivateKey          string `json:"privateKey,omitempty"          redact:"true" validate:"required_with=AppID"`
+	// PrivateKeySource is a secret reference to resolve PrivateKey from
+	PrivateKeySource string `json:"privateKeySource,omitempty"`
+}
+
+// Allows reports whether fullName ("owner/repo") is in Repositories, ignoring case
+func (s SourceConfig) Allows(fullName string) bool {
+	owner, _, _ := strings.Cut(fullName, "/")
+	for _, r := range s.Repositories {
+		if strings.EqualFold(r, fullName) || strings.EqualFold(r, owner+"/*") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// GitHubAppConfig returns base with the GitHub App installation of the source
+func (s SourceConfig) GitHubAppConfig(base GitHubAppConfig) GitHubAppConfig {
+	if s.InstallationID != 0 {
+		base.InstallationID = s.InstallationID
+	}
+	if s.AppID != 0 {
+		base.AppID = s.AppID
+		base.PrivateKey = s.PrivateKey
+		base.PrivateKeySource = s.PrivateKeySource
+	}
+
+	return base
+}
+
+// PoolConfig keeps Size idle ephemeral runners registered to Repository with Labels, so that workflow jobs
+// matching the labels are picked up without waiting for a new execution to start.
+type PoolConfig struct {
+	Name string `json:"name" validate:"required"`
+	// Repository is "owner/repo" to register the runners to
+	Repository string `json:"repository" validate:"required,contains=/"`
+	// Labels must include "self-hosted" and "job-manifest=<path>", like the labels of workflow jobs
+	Labels []string `json:"labels" validate:"min=1"`
+	// Ref is the git ref to download the job manifest from. The default branch is used when empty.
+	Ref  string `json:"ref,omitempty"`
+	Size int    `json:"size" validate:"gte=0"`
+	// IdleTTL is how long a runner stays idle in the pool before it is replaced with a new one. Zero means no limit.
+	IdleTTL Duration `json:"idleTTL,omitempty" validate:"gte=0"`
+}
+
+// Owner returns the owner of Repository
+func (c PoolConfig) Owner() string {
+	owner, _, _ := strings.Cut(c.Repository, "/")
+	return owner
+}
+
+// Repo returns the repository name of Repository
+func (c PoolConfig) Repo() string {
+	_, repo, _ := strings.Cut(c.Repository, "/")
+	return repo
+}
+
+// APIBaseURL returns the REST API URL without a trailing slash
+func (c GitHubAppConfig) APIBaseURL() string {
+	if c.APIURL == "" {
+		return "https://api.github.com"
+	}
+
+	u := strings.TrimSuffix(c.APIURL, "/")
+	if !strings.HasSuffix(u, "/api/v3") {
+		u += "/api/v3"
+	}
+	return u
+}
+
+// WebBaseURL returns the web URL without a trailing slash.
+// Without WebURL, it is the host of APIURL, since the API of GitHub Enterprise Server is served at "/api/v3" of the web URL.
+func (c GitHubAppConfig) WebBaseURL() string {
+	if c.WebURL == "" && c.APIURL != "" {
+		return strings.TrimSuffix(c.APIBaseURL(), "/api/v3")
+	}
+	if c.WebURL == "" {
+		return "https://github.com"
+	}
+
+	return strings.TrimSuffix(c.WebURL, "/")
+}
+
+// OrgLimit returns the limit for owner
+func (c LimitsConfig) OrgLimit(owner string) int {
+	if l, ok := c.Orgs[owner]; ok {
+		return l
+	}
+	return c.PerOrg
+}
+
+// RepoLimit returns the limit for "owner/repo"
+func (c LimitsConfig) RepoLimit(fullName string) int {
+	if l, ok := c.Repos[fullName]; ok {
+		return l
+	}
+	return c.PerRepo
+}
+
+// ActiveWebhookSecrets returns the webhook secrets to validate payloads with, WebhookSecret first
+func (c ServerConfig) ActiveWebhookSecrets() []string {
+	secrets := make([]string, 0, len(c.WebhookSecrets)+1)
+	for _, s := range append([]string{c.WebhookSecret}, c.WebhookSecrets...) {
+		if s != "" {
+			secrets = append(secrets, s)
+		}
+	}
+
+	return secrets
+}
+
+// fileConfig is the format of the config file.
+// Env holds the settings named after the environment variables, and the environment variables take precedence over them.
+type fileConfig struct {
+	Env     map[string]string     `json:"env"`
+	Limits  LimitsConfig          `json:"limits"`
+	Pools   []PoolConfig          `json:"pools"`
+	Sizes   map[string]SizeConfig `json:"sizes"`
+	Sources []SourceConfig        `json:"sources"`
+}
+
+const configFileEnv = "CONFIG_FILE"
+
+var (
+	instance atomic.Pointer[Config]
+	validate = validator.New()
 
+	// secretResolver resolves the *Source settings. It is replaceable for tests.
+	secretResolver secret.Resolver = secret.NewDefaultResolver()
+
+	// fallbackProjectID is GCPConfig.ProjectID when GOOGLE_CLOUD_PROJECT is not set
+	fallbackProjectID atomic.Pointer[string]
+)
+
+const secretResolveTimeout = 10 * time.Second
+
+// Load loads the config from CONFIG_FILE and the environment variables, validates it and replaces the current config
 func Load() (*Config, error) {
-	instance = new(Config)
-	if err := env.Parse(instance); err != nil {
+	c, err := load(os.Getenv(configFileEnv))
+	if err != nil {
+		return nil, err
+	}
+
+	instance.Store(c)
+
+	return c, nil
+}
+
+func load(path string) (*Config, error) {
+	environment := make(map[string]string)
+
+	fc := new(fileConfig)
+	if path != "" {
+		var err error
+		if fc, err = readFile(path); err != nil {
+			return nil, err
+		}
+		for k, v := range fc.Env {
+			environment[k] = v
+		}
+	}
+
+	// empty environment variables do not override the config file
+	for _, kv := range os.Environ() {
+		if k, v, ok := strings.Cut(kv, "="); ok && v != "" {
+			environment[k] = v
+		}
+	}
+
+	c := new(Config)
+	if err := env.ParseWithOptions(c, env.Options{Environment: environment}); err != nil {
 		return nil, fmt.Errorf("failed to parse Config: %w", err)
 	}
 
-	return instance, nil
+	c.LimitsConfig = fc.Limits
+	c.PoolsConfig = fc.Pools
+	c.SizesConfig = fc.Sizes
+	if len(c.SizesConfig) == 0 {
+		c.SizesConfig = DefaultSizes
+	}
+	c.SourcesConfig = fc.Sources
+
+	if err := resolveSecrets(c); err != nil {
+		return nil, err
+	}
+
+	if err := validate.Struct(c); err != nil {
+		return nil, fmt.Errorf("invalid Config: %w", err)
+	}
+	// the hooks IP ranges of GitHub.com do not include GitHub Enterprise Server, whose meta API may not publish them
+	if c.ServerConfig.WebhookIPAllowlist && c.GitHubAppConfig.APIURL != "" && len(c.ServerConfig.WebhookIPRanges) == 0 {
+		return nil, fmt.Errorf("invalid Config: WEBHOOK_IP_RANGES is required to enable WEBHOOK_IP_ALLOWLIST with GH_API_URL")
+	}
+
+	return c, nil
+}
+
+// resolveSecrets replaces the secrets with the values resolved from their sources if set
+func resolveSecrets(c *Config) error {
+	ctx, cancel := context.WithTimeout(context.Background(), secretResolveTimeout)
+	defer cancel()
+
+	type secretRef struct {
+		value  *string
+		source string
+	}
+	secrets := []secretRef{
+		{value: &c.ServerConfig.WebhookSecret, source: c.ServerConfig.WebhookSecretSource},
+		{value: &c.GitHubAppConfig.PrivateKey, source: c.GitHubAppConfig.PrivateKeySource},
+	}
+	for i := range c.SourcesConfig {
+		s := &c.SourcesConfig[i]
+		secrets = append(secrets,
+			secretRef{value: &s.WebhookSecret, source: s.WebhookSecretSource},
+			secretRef{value: &s.PrivateKey, source: s.PrivateKeySource},
+		)
+	}
+
+	for _, s := range secrets {
+		if s.source == "" {
+			continue
+		}
+
+		v, err := secretResolver.Resolve(ctx, s.source)
+		if err != nil {
+			return fmt.Errorf("failed to resolve secret: source=%s, %w", s.source, err)
+		}
+		*s.value = v
+	}
+
+	return nil
+}
+
+func readFile(path string) (*fileConfig, error) {
+	b, err := os.ReadFile(path) // nolint:gosec
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: path=%s, %w", path, err)
+	}
+
+	fc := new(fileConfig)
+	// YAML is a superset of JSON, so that both formats are supported
+	if err := k8syaml.UnmarshalStrict(b, fc); err != nil {
+		return nil, fmt.Errorf("failed to parse config file: path=%s, %w", path, err)
+	}
+
+	return fc, nil
 }
 
+// Get returns the current config. The returned config must not be modified.
+// Callers reading multiple settings should keep the returned value, so that they see a consistent snapshot across reloads.
 func Get() *Config {
-	return instance
+	return instance.Load()
 }
 
 func GetServerConfig() ServerConfig {
-	return instance.ServerConfig
+	return Get().ServerConfig
 }
 
+// GetGCPConfig returns the GCP config, with the project set by SetFallbackProjectID when GOOGLE_CLOUD_PROJECT is not set
 func GetGCPConfig() GCPConfig {
-	return instance.GCPConfig
+	c := Get().GCPConfig
+	if p := fallbackProjectID.Load(); c.ProjectID == "" && p != nil {
+		c.ProjectID = *p
+	}
+	return c
+}
+
+// SetFallbackProjectID sets the project used when GOOGLE_CLOUD_PROJECT is not set, e.g. the one of the metadata server.
+// It is kept across reloads.
+func SetFallbackProjectID(projectID string) {
+	fallbackProjectID.Store(&projectID)
 }
 
 func GetGitHubAppConfig() GitHubAppConfig {
-	return instance.GitHubAppConfig
+	return Get().GitHubAppConfig
+}
+
+func GetTracingConfig() TracingConfig {
+	return Get().TracingConfig
+}
+
+func GetGCConfig() GCConfig {
+	return Get().GCConfig
+}
+
+func GetSizesConfig() map[string]SizeConfig {
+	return Get().SizesConfig
+}
+
+func GetLimitsConfig() LimitsConfig {
+	return Get().LimitsConfig
+}
+
+func GetPoolsConfig() []PoolConfig {
+	return Get().PoolsConfig
+}
+
+// GetSourceConfig returns the webhook source named name
+func GetSourceConfig(name string) (SourceConfig, bool) {
+	for _, s := range Get().SourcesConfig {
+		if s.Name == name {
+			return s, true
+		}
+	}
+
+	return SourceConfig{}, false
 }
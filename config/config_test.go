@@ -0,0 +1,265 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func setRequiredEnv(t *testing.T) {
+	t.Helper()
+	t.Setenv("WEBHOOK_SECRET", "secret")
+	t.Setenv("GH_APP_PRIVATE_KEY", "key")
+	t.Setenv("GH_APP_ID", "1")
+	t.Setenv("GH_APP_INSTALLATION_ID", "2")
+}
+
+func writeConfigFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("failed to write config file: %v", err)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	setRequiredEnv(t)
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfigFile(t, path, `
+env:
+  LOG_LEVEL: debug
+  PORT: "9090"
+  DEFAULT_TIMEOUT: 20s
+pools:
+  - name: small
+    repository: org/repo
+    labels: [self-hosted, job-manifest=small.yaml]
+    size: 2
+    idleTTL: 30m
+`)
+	t.Setenv("CONFIG_FILE", path)
+	t.Setenv("PORT", "8081")
+
+	got, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	if got.ServerConfig.LogLevel != "debug" {
+		t.Errorf("LogLevel = %s, want the value from the config file", got.ServerConfig.LogLevel)
+	}
+	if got.ServerConfig.Port != 8081 {
+		t.Errorf("Port = %d, want the environment variable to take precedence", got.ServerConfig.Port)
+	}
+	if got.ServerConfig.DefaultTimeout != 20*time.Second {
+		t.Errorf("DefaultTimeout = %s, want the value from the config file", got.ServerConfig.DefaultTimeout)
+	}
+	if got.ServerConfig.ReadinessCacheTTL != 30*time.Second {
+		t.Errorf("ReadinessCacheTTL = %s, want the default value", got.ServerConfig.ReadinessCacheTTL)
+	}
+	if len(got.PoolsConfig) != 1 || got.PoolsConfig[0].Owner() != "org" || time.Duration(got.PoolsConfig[0].IdleTTL) != 30*time.Minute {
+		t.Errorf("PoolsConfig = %+v, want the pool from the config file", got.PoolsConfig)
+	}
+	if Get() != got {
+		t.Error("Get() does not return the loaded config")
+	}
+}
+
+func TestLoad_Invalid(t *testing.T) {
+	setRequiredEnv(t)
+	path := filepath.Join(t.TempDir(), "config.json")
+	t.Setenv("CONFIG_FILE", path)
+
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{name: "validation error", content: `{"env": {"LOG_LEVEL": "verbose"}}`},
+		{name: "unknown field", content: `{"environment": {}}`},
+		{name: "broken file", content: `{`},
+		{name: "invalid duration", content: `{"pools": [{"name": "p", "repository": "org/repo", "labels": ["self-hosted"], "idleTTL": "soon"}]}`},
+		{name: "ip allowlist of ghes without ranges", content: `{"env": {"WEBHOOK_IP_ALLOWLIST": "true", "GH_API_URL": "https://ghes.example.com/api/v3"}}`},
+		{name: "source without repositories", content: `{"sources": [{"name": "repo", "webhookSecret": "secret"}]}`},
+		{name: "invalid ip range", content: `{"env": {"WEBHOOK_IP_RANGES": "10.0.0.0/8,ghes"}}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			writeConfigFile(t, path, tt.content)
+			if _, err := Load(); err == nil {
+				t.Error("Load() error = nil, want error")
+			}
+		})
+	}
+}
+
+func TestReload(t *testing.T) {
+	setRequiredEnv(t)
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfigFile(t, path, "env: {LOG_LEVEL: info}")
+	t.Setenv("CONFIG_FILE", path)
+
+	if _, err := Load(); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	var notified *Config
+	OnReload(func(c *Config) { notified = c })
+
+	writeConfigFile(t, path, "env: {LOG_LEVEL: verbose}")
+	if _, err := reload(path); err == nil {
+		t.Error("reload() error = nil, want validation error")
+	}
+	if got := GetServerConfig().LogLevel; got != "info" {
+		t.Errorf("LogLevel = %s after an invalid reload, want the current config to stay", got)
+	}
+
+	writeConfigFile(t, path, "env: {LOG_LEVEL: warn}")
+	if _, err := reload(path); err != nil {
+		t.Fatalf("reload() error = %v", err)
+	}
+	if got := GetServerConfig().LogLevel; got != "warn" {
+		t.Errorf("LogLevel = %s, want warn", got)
+	}
+	if notified != Get() {
+		t.Error("OnReload hook is not called with the new config")
+	}
+}
+
+func TestLoad_SecretSource(t *testing.T) {
+	setRequiredEnv(t)
+	dir := t.TempDir()
+	secretPath := filepath.Join(dir, "webhook-secret")
+	writeConfigFile(t, secretPath, "rotated\n")
+	t.Setenv("WEBHOOK_SECRET", "")
+	t.Setenv("WEBHOOK_SECRET_SOURCE", "file://"+secretPath)
+	t.Setenv("CONFIG_FILE", "")
+
+	got, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if got.ServerConfig.WebhookSecret != "rotated" {
+		t.Errorf("WebhookSecret = %q, want the value resolved from the source", got.ServerConfig.WebhookSecret)
+	}
+
+	writeConfigFile(t, secretPath, "rotated-again")
+	changed, err := reload("")
+	if err != nil {
+		t.Fatalf("reload() error = %v", err)
+	}
+	if !changed || GetServerConfig().WebhookSecret != "rotated-again" {
+		t.Errorf("reload() did not pick up the rotated secret: changed=%v", changed)
+	}
+}
+
+func TestSourceConfig_Allows(t *testing.T) {
+	s := SourceConfig{Repositories: []string{"org/repo", "other-org/*"}}
+
+	tests := []struct {
+		fullName string
+		want     bool
+	}{
+		{fullName: "org/repo", want: true},
+		{fullName: "Org/Repo", want: true},
+		{fullName: "org/other", want: false},
+		{fullName: "other-org/any", want: true},
+		{fullName: "other-org-2/any", want: false},
+		{fullName: "", want: false},
+	}
+	for _, tt := range tests {
+		if got := s.Allows(tt.fullName); got != tt.want {
+			t.Errorf("Allows(%q) = %v, want %v", tt.fullName, got, tt.want)
+		}
+	}
+}
+
+func TestHasSecretSources(t *testing.T) {
+	tests := []struct {
+		name string
+		conf Config
+		want bool
+	}{
+		{name: "without secret sources", conf: Config{SourcesConfig: []SourceConfig{{Name: "a", WebhookSecret: "secret"}}}},
+		{name: "webhook secret source", conf: Config{ServerConfig: ServerConfig{WebhookSecretSource: "file:///secret"}}, want: true},
+		{name: "webhook secret source of a source", conf: Config{SourcesConfig: []SourceConfig{{Name: "a"}, {Name: "b", WebhookSecretSource: "file:///secret"}}}, want: true},
+		{name: "private key source of a source", conf: Config{SourcesConfig: []SourceConfig{{Name: "a", PrivateKeySource: "file:///key"}}}, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := hasSecretSources(&tt.conf); got != tt.want {
+				t.Errorf("hasSecretSources() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGitHubAppConfig_BaseURLs(t *testing.T) {
+	tests := []struct {
+		name    string
+		conf    GitHubAppConfig
+		wantAPI string
+		wantWeb string
+	}{
+		{name: "github.com", wantAPI: "https://api.github.com", wantWeb: "https://github.com"},
+		{
+			name:    "ghes",
+			conf:    GitHubAppConfig{APIURL: "https://ghes.example.com/api/v3/", WebURL: "https://ghes.example.com/"},
+			wantAPI: "https://ghes.example.com/api/v3",
+			wantWeb: "https://ghes.example.com",
+		},
+		{
+			name:    "ghes without the web url",
+			conf:    GitHubAppConfig{APIURL: "https://ghes.example.com/api/v3"},
+			wantAPI: "https://ghes.example.com/api/v3",
+			wantWeb: "https://ghes.example.com",
+		},
+		{
+			name:    "ghes without the api path",
+			conf:    GitHubAppConfig{APIURL: "https://ghes.example.com"},
+			wantAPI: "https://ghes.example.com/api/v3",
+			wantWeb: "https://ghes.example.com",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.conf.APIBaseURL(); got != tt.wantAPI {
+				t.Errorf("APIBaseURL() = %s, want %s", got, tt.wantAPI)
+			}
+			if got := tt.conf.WebBaseURL(); got != tt.wantWeb {
+				t.Errorf("WebBaseURL() = %s, want %s", got, tt.wantWeb)
+			}
+		})
+	}
+}
+
+func TestGetGCPConfig_FallbackProjectID(t *testing.T) {
+	setRequiredEnv(t)
+	t.Setenv("CONFIG_FILE", "")
+	t.Setenv("GOOGLE_CLOUD_PROJECT", "")
+	t.Cleanup(func() { fallbackProjectID.Store(nil) })
+
+	if _, err := Load(); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	SetFallbackProjectID("metadata-project")
+	if got := GetGCPConfig().ProjectID; got != "metadata-project" {
+		t.Errorf("ProjectID = %s, want the fallback project", got)
+	}
+
+	// the fallback is kept across reloads, and GOOGLE_CLOUD_PROJECT takes precedence
+	t.Setenv("LOG_LEVEL", "debug")
+	if _, err := reload(""); err != nil {
+		t.Fatalf("reload() error = %v", err)
+	}
+	if got := GetGCPConfig().ProjectID; got != "metadata-project" {
+		t.Errorf("ProjectID = %s after reload, want the fallback project", got)
+	}
+	t.Setenv("GOOGLE_CLOUD_PROJECT", "my-project")
+	if _, err := reload(""); err != nil {
+		t.Fatalf("reload() error = %v", err)
+	}
+	if got := GetGCPConfig().ProjectID; got != "my-project" {
+		t.Errorf("ProjectID = %s, want GOOGLE_CLOUD_PROJECT", got)
+	}
+}
@@ -0,0 +1,51 @@
+package config
+
+import (
+	"reflect"
+)
+
+const redactedValue = "[REDACTED]"
+
+// Redacted returns a copy of c where non-empty fields tagged `redact:"true"` are replaced with a placeholder
+func Redacted(c Config) Config {
+	redact(reflect.ValueOf(&c).Elem())
+	return c
+}
+
+func redact(v reflect.Value) {
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Field(i)
+		if !field.CanSet() {
+			continue
+		}
+
+		sensitive := v.Type().Field(i).Tag.Get("redact") == "true"
+
+		switch field.Kind() {
+		case reflect.Struct:
+			redact(field)
+		case reflect.String:
+			if sensitive && field.Len() > 0 {
+				field.SetString(redactedValue)
+			}
+		case reflect.Slice:
+			if !field.IsNil() && field.Type().Elem().Kind() == reflect.Struct {
+				// copy the slice so that the original config is not modified
+				redacted := reflect.MakeSlice(field.Type(), field.Len(), field.Len())
+				reflect.Copy(redacted, field)
+				for j := 0; j < redacted.Len(); j++ {
+					redact(redacted.Index(j))
+				}
+				field.Set(redacted)
+			}
+			if sensitive && field.Type().Elem().Kind() == reflect.String {
+				// copy the slice so that the original config is not modified
+				redacted := reflect.MakeSlice(field.Type(), field.Len(), field.Len())
+				for j := 0; j < field.Len(); j++ {
+					redacted.Index(j).SetString(redactedValue)
+				}
+				field.Set(redacted)
+			}
+		}
+	}
+}
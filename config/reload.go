@@ -0,0 +1,119 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+var (
+	reloadHooks   []func(*Config)
+	reloadHooksMu sync.Mutex
+)
+
+// OnReload registers fn to be called with the new config after the config file is reloaded
+func OnReload(fn func(*Config)) {
+	reloadHooksMu.Lock()
+	defer reloadHooksMu.Unlock()
+
+	reloadHooks = append(reloadHooks, fn)
+}
+
+// Watch reloads the config when the config file changes, and resolves the secret sources periodically until ctx is done.
+// An invalid config is logged and ignored, so that the current config stays in effect.
+func Watch(ctx context.Context) {
+	conf := GetServerConfig()
+	logger := zerolog.Ctx(ctx)
+
+	var fileTick, secretTick <-chan time.Time
+	if conf.ConfigFile != "" && conf.ConfigReloadInterval > 0 {
+		ticker := time.NewTicker(conf.ConfigReloadInterval)
+		defer ticker.Stop()
+		fileTick = ticker.C
+	}
+	if hasSecretSources(Get()) && conf.SecretRefreshInterval > 0 {
+		ticker := time.NewTicker(conf.SecretRefreshInterval)
+		defer ticker.Stop()
+		secretTick = ticker.C
+	}
+	if fileTick == nil && secretTick == nil {
+		return
+	}
+
+	var last []byte
+	if conf.ConfigFile != "" {
+		b, err := os.ReadFile(conf.ConfigFile)
+		if err != nil {
+			logger.Warn().Err(err).Msgf("failed to read config file: path=%s", conf.ConfigFile)
+		}
+		last = b
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-fileTick:
+			b, err := os.ReadFile(conf.ConfigFile)
+			if err != nil {
+				logger.Warn().Err(err).Msgf("failed to read config file: path=%s", conf.ConfigFile)
+				continue
+			}
+			if bytes.Equal(b, last) {
+				continue
+			}
+			last = b
+		case <-secretTick:
+		}
+
+		changed, err := reload(conf.ConfigFile)
+		if err != nil {
+			logger.Error().Err(err).Msgf("failed to reload config, keeping the current config: path=%s", conf.ConfigFile)
+			continue
+		}
+		if changed {
+			logger.Info().Msgf("config reloaded: path=%s", conf.ConfigFile)
+		}
+	}
+}
+
+// reload loads the config and replaces the current one if changed
+func reload(path string) (bool, error) {
+	c, err := load(path)
+	if err != nil {
+		return false, err
+	}
+
+	if reflect.DeepEqual(c, Get()) {
+		return false, nil
+	}
+	instance.Store(c)
+
+	reloadHooksMu.Lock()
+	hooks := reloadHooks
+	reloadHooksMu.Unlock()
+
+	for _, fn := range hooks {
+		fn(c)
+	}
+
+	return true, nil
+}
+
+// hasSecretSources reports whether any secret of c, including the ones of the webhook sources, is resolved from a secret source
+func hasSecretSources(c *Config) bool {
+	if c.ServerConfig.WebhookSecretSource != "" || c.GitHubAppConfig.PrivateKeySource != "" {
+		return true
+	}
+	for _, s := range c.SourcesConfig {
+		if s.WebhookSecretSource != "" || s.PrivateKeySource != "" {
+			return true
+		}
+	}
+	return false
+}
@@ -14,8 +14,9 @@ if ! cd "${RUNNER_HOME}"; then
   exit 1
 fi
 
-if [ -z "${ACCESS_TOKEN}" ]; then
-  echo "ACCESS_TOKEN is not set" >&2
+# RUNNER_TOKEN is passed by the controller in the execution overrides for the workflow jobs of the other webhook sources
+if [ -z "${ACCESS_TOKEN}" ] && [ -z "${RUNNER_TOKEN}" ]; then
+  echo "ACCESS_TOKEN or RUNNER_TOKEN is not set" >&2
   exit 1
 fi
 
@@ -34,20 +35,33 @@ if [ -z "${LABELS}" ]; then
   exit 1
 fi
 
-if [ -z "${RUNNER_NAME}" ]; then
-  echo "RUNNER_NAME is not set, using Cloud Run Jobs ExecutionID instead"
+# GitHub Enterprise Server endpoints are passed by the controller
+GITHUB_API_URL=${GITHUB_API_URL:-https://api.github.com}
+GITHUB_URL=${GITHUB_URL:-https://github.com}
+
+# Name the runner after the Cloud Run Jobs execution, so that the runner in the GitHub UI is traceable to the execution
+if [ -n "${CLOUD_RUN_EXECUTION}" ]; then
   RUNNER_NAME=${CLOUD_RUN_EXECUTION}
+  # Each task of an execution for batched workflow jobs registers its own runner
+  if [ "${CLOUD_RUN_TASK_COUNT:-1}" -gt 1 ]; then
+    RUNNER_NAME="${RUNNER_NAME}-${CLOUD_RUN_TASK_INDEX}"
+  fi
+elif [ -z "${RUNNER_NAME}" ]; then
+  echo "RUNNER_NAME is not set outside Cloud Run Jobs" >&2
+  exit 1
 fi
 
-RUNNER_TOKEN=$(curl \
-  -sf \
-  -X POST \
-  -H "Accept: application/vnd.github+json" \
-  -H "Authorization: Bearer ${ACCESS_TOKEN}" \
-  -H "X-GitHub-Api-Version: 2022-11-28" \
-  "https://api.github.com/repos/${OWNER}/${REPO}/actions/runners/registration-token" \
-  | jq -r .token
-)
+if [ -z "${RUNNER_TOKEN}" ]; then
+  RUNNER_TOKEN=$(curl \
+    -sf \
+    -X POST \
+    -H "Accept: application/vnd.github+json" \
+    -H "Authorization: Bearer ${ACCESS_TOKEN}" \
+    -H "X-GitHub-Api-Version: 2022-11-28" \
+    "${GITHUB_API_URL}/repos/${OWNER}/${REPO}/actions/runners/registration-token" \
+    | jq -r .token
+  )
+fi
 
 config_args=()
 if [ "${DISABLE_RUNNER_UPDATE:-}" == "true" ]; then
@@ -56,7 +70,7 @@ if [ "${DISABLE_RUNNER_UPDATE:-}" == "true" ]; then
 fi
 
 ./config.sh --unattended \
-  --url "https://github.com/${OWNER}/${REPO}" \
+  --url "${GITHUB_URL}/${OWNER}/${REPO}" \
   --token "${RUNNER_TOKEN}" \
   --labels "${LABELS}" \
   --name "${RUNNER_NAME}" \
@@ -65,6 +79,6 @@ fi
   "${config_args[@]}"
 
 # Unset entrypoint environment variables so they don't leak into the runner environment
-unset OWNER REPO LABELS ACCESS_TOKEN RUNNER_TOKEN RUNNER_NAME
+unset OWNER REPO LABELS ACCESS_TOKEN RUNNER_TOKEN RUNNER_NAME GITHUB_API_URL GITHUB_URL
 
 ./run.sh
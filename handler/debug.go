@@ -0,0 +1,117 @@
+package handler
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/karahiyo/actions-job/config"
+	"github.com/karahiyo/actions-job/service"
+	"github.com/rs/zerolog/log"
+)
+
+// HandleDebugConfig shows the effective config with secrets redacted.
+// Requests must have "Authorization: Bearer <DEBUG_TOKEN>", and the endpoint is disabled if DEBUG_TOKEN is not set.
+func HandleDebugConfig() func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !authorizeDebug(w, r) {
+			return
+		}
+
+		writeJSON(w, config.Redacted(*config.Get()))
+	}
+}
+
+// HandleDebugGC runs a garbage collection and shows its report. GET only reports what would be deleted,
+// and POST deletes them unless GC_DRY_RUN is set. It is authorized like HandleDebugConfig.
+func HandleDebugGC(controller *service.Controller) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !authorizeDebug(w, r) {
+			return
+		}
+
+		var dryRun bool
+		switch r.Method {
+		case http.MethodGet:
+			dryRun = true
+		case http.MethodPost:
+			dryRun = config.GetGCConfig().DryRun
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+
+		report, err := controller.CollectGarbage(r.Context(), dryRun)
+		if err != nil {
+			log.Error().Err(err).Msg("failed to collect garbage")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+
+		writeJSON(w, report)
+	}
+}
+
+// HandleDebugExecution shows the Cloud Run job execution started for a workflow job at /debug/executions/{workflow_job_id},
+// with links to the execution and its logs. It is authorized like HandleDebugConfig.
+func HandleDebugExecution(controller *service.Controller) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !authorizeDebug(w, r) {
+			return
+		}
+
+		id, err := strconv.ParseInt(strings.TrimPrefix(r.URL.Path, "/debug/executions/"), 10, 64)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		link, ok := controller.LookupExecution(id)
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		writeJSON(w, link)
+	}
+}
+
+// HandleDebugInstallations shows the installations of the GitHub App known from the webhook events.
+// It is authorized like HandleDebugConfig.
+func HandleDebugInstallations(controller *service.Controller) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !authorizeDebug(w, r) {
+			return
+		}
+
+		writeJSON(w, controller.Installations())
+	}
+}
+
+// authorizeDebug checks the bearer token of the debug endpoints, and writes the error response if it does not match
+func authorizeDebug(w http.ResponseWriter, r *http.Request) bool {
+	token := config.GetServerConfig().DebugToken
+	if token == "" {
+		w.WriteHeader(http.StatusNotFound)
+		return false
+	}
+
+	given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+		w.WriteHeader(http.StatusUnauthorized)
+		return false
+	}
+
+	return true
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		log.Error().Err(err).Msg("failed writing http response")
+	}
+}
@@ -0,0 +1,34 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/karahiyo/actions-job/service"
+	"github.com/rs/zerolog/log"
+)
+
+// HandleHealthz reports that the process is alive
+func HandleHealthz() func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		if _, err := w.Write([]byte("ok")); err != nil {
+			log.Error().Err(err).Msg("failed writing http response")
+		}
+	}
+}
+
+// HandleReadyz reports whether the controller can reach GitHub and Cloud Run with its credentials
+func HandleReadyz(controller *service.Controller) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := controller.CheckReadiness(r.Context()); err != nil {
+			log.Warn().Err(err).Msg("readiness check failed")
+			http.Error(w, "not ready", http.StatusServiceUnavailable)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		if _, err := w.Write([]byte("ok")); err != nil {
+			log.Error().Err(err).Msg("failed writing http response")
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package handler
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// clientIP returns the IP address of the client sending r. X-Forwarded-For is only honoured when the request is
+// sent by one of trustedProxies, then the rightmost address not in trustedProxies is the client.
+func clientIP(r *http.Request, trustedProxies []string) (netip.Addr, error) {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	remote, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("failed to parse remote address: addr=%s, %w", r.RemoteAddr, err)
+	}
+	remote = remote.Unmap()
+
+	trusted := make([]netip.Prefix, 0, len(trustedProxies))
+	for _, p := range trustedProxies {
+		prefix, err := netip.ParsePrefix(p)
+		if err != nil {
+			return netip.Addr{}, fmt.Errorf("failed to parse trusted proxy: %w", err)
+		}
+		trusted = append(trusted, prefix)
+	}
+	isTrusted := func(addr netip.Addr) bool {
+		for _, p := range trusted {
+			if p.Contains(addr) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if !isTrusted(remote) {
+		return remote, nil
+	}
+
+	var forwarded []string
+	for _, h := range r.Header.Values("X-Forwarded-For") {
+		forwarded = append(forwarded, strings.Split(h, ",")...)
+	}
+
+	client := remote
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		addr, err := netip.ParseAddr(strings.TrimSpace(forwarded[i]))
+		if err != nil {
+			return netip.Addr{}, fmt.Errorf("failed to parse X-Forwarded-For: %w", err)
+		}
+		client = addr.Unmap()
+		if !isTrusted(client) {
+			break
+		}
+	}
+
+	return client, nil
+}
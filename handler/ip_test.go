@@ -0,0 +1,45 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	trusted := []string{"10.0.0.0/8", "169.254.0.0/16"}
+
+	tests := []struct {
+		name         string
+		remoteAddr   string
+		forwardedFor string
+		trusted      []string
+		want         string
+		wantErr      bool
+	}{
+		{name: "direct", remoteAddr: "140.82.115.1:443", want: "140.82.115.1"},
+		{name: "untrusted proxy", remoteAddr: "203.0.113.1:443", forwardedFor: "140.82.115.1", trusted: trusted, want: "203.0.113.1"},
+		{name: "no trusted proxies", remoteAddr: "10.0.0.1:443", forwardedFor: "140.82.115.1", want: "10.0.0.1"},
+		{name: "trusted proxy", remoteAddr: "169.254.1.1:443", forwardedFor: "140.82.115.1", trusted: trusted, want: "140.82.115.1"},
+		{name: "spoofed by client", remoteAddr: "169.254.1.1:443", forwardedFor: "140.82.115.1, 203.0.113.1, 10.0.0.1", trusted: trusted, want: "203.0.113.1"},
+		{name: "broken header", remoteAddr: "169.254.1.1:443", forwardedFor: "unknown", trusted: trusted, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/github/events", nil)
+			r.RemoteAddr = tt.remoteAddr
+			if tt.forwardedFor != "" {
+				r.Header.Set("X-Forwarded-For", tt.forwardedFor)
+			}
+
+			got, err := clientIP(r, tt.trusted)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("clientIP() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got.String() != tt.want {
+				t.Errorf("clientIP() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package handler
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+
+	"github.com/google/go-github/v52/github"
+)
+
+var errNoWebhookSecret = errors.New("no webhook secret is configured")
+
+// validatePayload validates the payload signature with each of secrets in turn.
+// It returns the payload and the label of the secret that matched.
+func validatePayload(r *http.Request, secrets []string) ([]byte, string, error) {
+	if len(secrets) == 0 {
+		return nil, "", errNoWebhookSecret
+	}
+
+	signature := r.Header.Get(github.SHA256SignatureHeader)
+	if signature == "" {
+		signature = r.Header.Get(github.SHA1SignatureHeader)
+	}
+
+	contentType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to parse content type: %w", err)
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read request body: %w", err)
+	}
+
+	var errs []error
+	for i, secret := range secrets {
+		payload, err := github.ValidatePayloadFromBody(contentType, bytes.NewReader(body), signature, []byte(secret))
+		if err == nil {
+			return payload, secretLabel(i), nil
+		}
+		errs = append(errs, err)
+	}
+
+	return nil, "", fmt.Errorf("payload did not match any of %d webhook secrets: %w", len(secrets), errors.Join(errs...))
+}
+
+// secretLabel identifies the secret at index of the accepted secrets in logs and metrics without revealing it,
+// "current" for the first one and "previous-<index>" for the others
+func secretLabel(index int) string {
+	if index == 0 {
+		return "current"
+	}
+	return fmt.Sprintf("previous-%d", index)
+}
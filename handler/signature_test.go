@@ -0,0 +1,61 @@
+package handler
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/v52/github"
+)
+
+func newSignedRequest(t *testing.T, body, secret string) *http.Request {
+	t.Helper()
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(body))
+
+	r := httptest.NewRequest(http.MethodPost, "/github/events", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set(github.SHA256SignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+
+	return r
+}
+
+func TestValidatePayload(t *testing.T) {
+	const body = `{"zen":"Keep it logically awesome."}`
+
+	tests := []struct {
+		name       string
+		signedWith string
+		wantLabel  string
+		secrets    []string
+		wantErr    bool
+	}{
+		{name: "current secret", signedWith: "new", secrets: []string{"new", "old"}, wantLabel: "current"},
+		{name: "previous secret", signedWith: "old", secrets: []string{"new", "old"}, wantLabel: "previous-1"},
+		{name: "retired secret", signedWith: "old", secrets: []string{"new"}, wantErr: true},
+		{name: "no secrets", signedWith: "old", secrets: nil, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload, label, err := validatePayload(newSignedRequest(t, body, tt.signedWith), tt.secrets)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("validatePayload() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if string(payload) != body {
+				t.Errorf("validatePayload() payload = %s, want %s", payload, body)
+			}
+			if label != tt.wantLabel {
+				t.Errorf("validatePayload() label = %s, want %s", label, tt.wantLabel)
+			}
+		})
+	}
+}
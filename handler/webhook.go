@@ -3,32 +3,89 @@ package handler
 import (
 	"errors"
 	"net/http"
-	"os"
+	"strings"
 
 	"github.com/google/go-github/v52/github"
 	"github.com/karahiyo/actions-job/config"
+	"github.com/karahiyo/actions-job/logging"
+	"github.com/karahiyo/actions-job/metrics"
 	"github.com/karahiyo/actions-job/service"
-	"github.com/rs/zerolog"
+	"github.com/karahiyo/actions-job/tracing"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.20.0"
 )
 
 var ErrBadRequest = errors.New("bad request")
 
+// HandleWebhookEvents handles the webhook events of the GitHub App at /github/events,
+// and of the webhook sources in SourcesConfig at /github/events/{source}, validated with their own secrets.
 func HandleWebhookEvents(controller *service.Controller) func(http.ResponseWriter, *http.Request) {
-	return func(w http.ResponseWriter, r *http.Request) {
+	return func(rw http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
 
-		logger := zerolog.New(os.Stdout).With().Timestamp().Logger()
+		w := &statusRecorder{ResponseWriter: rw, status: http.StatusOK}
+		eventType := github.WebHookType(r)
+		var action string
+
+		ctx, span := tracing.Start(tracing.Extract(ctx, r.Header), "HandleWebhookEvents",
+			tracing.AttrDeliveryID.String(github.DeliveryID(r)),
+			tracing.AttrEvent.String(eventType),
+		)
+		defer func() {
+			metrics.IncWebhookEvent(eventType, action, w.status)
+			span.SetAttributes(semconv.HTTPStatusCode(w.status))
+			if w.status >= http.StatusInternalServerError {
+				span.SetStatus(codes.Error, http.StatusText(w.status))
+			}
+			span.End()
+		}()
+
+		logger := log.Logger.With().
+			Str("delivery_id", github.DeliveryID(r)).
+			Str("event", eventType).
+			Logger()
+		logger = logging.WithTrace(ctx, logger, config.GetGCPConfig().ProjectID, r.Header)
 		ctx = logger.WithContext(ctx)
 
-		payload, err := github.ValidatePayload(r, []byte(config.GetServerConfig().WebhookSecret))
+		if serverConf := config.GetServerConfig(); serverConf.WebhookIPAllowlist {
+			ip, err := clientIP(r, serverConf.TrustedProxies)
+			if err != nil || !controller.AllowsWebhookIP(ip) {
+				logger.Warn().Err(err).Str("client_ip", ip.String()).Msg("rejected webhook event from outside the GitHub hooks ip ranges")
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+		}
+
+		source := strings.Trim(strings.TrimPrefix(r.URL.Path, "/github/events"), "/")
+		secrets := config.GetServerConfig().ActiveWebhookSecrets()
+		var sourceConf config.SourceConfig
+		if source != "" {
+			var ok bool
+			if sourceConf, ok = config.GetSourceConfig(source); !ok {
+				logger.Warn().Msgf("received event from unknown webhook source(%s), return NotFound", source)
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			secrets = []string{sourceConf.WebhookSecret}
+			logger = logger.With().Str("source", source).Logger()
+			ctx = logger.WithContext(ctx)
+		}
+
+		payload, matchedSecret, err := validatePayload(r, secrets)
 		if err != nil {
+			metrics.IncWebhookSignature(metrics.SecretUnmatched)
 			logger.Warn().Err(err).Msg("Could not validate request body")
 			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
-		logger.Info().Msgf("Received webhook event: payload=%s", string(payload))
+		metrics.IncWebhookSignature(matchedSecret)
+		logger = logger.With().Str("webhook_secret", matchedSecret).Logger()
+		ctx = logger.WithContext(ctx)
+		logger.Info().Msg("Received webhook event")
+		logger.Debug().RawJSON("payload", payload).Msg("webhook event payload")
 
-		webhookEvent, err := github.ParseWebHook(github.WebHookType(r), payload)
+		webhookEvent, err := github.ParseWebHook(eventType, payload)
 		if err != nil {
 			logger.Error().Err(err).Msg("Could not parse webhook")
 			w.WriteHeader(http.StatusBadRequest)
@@ -48,13 +105,50 @@ func HandleWebhookEvents(controller *service.Controller) func(http.ResponseWrite
 
 			return
 		case *github.WorkflowJobEvent:
-			if err := controller.ReceiveWorkflowJobEvent(ctx, event); err != nil {
+			action = event.GetAction()
+			span.SetAttributes(
+				tracing.AttrRepository.String(event.GetRepo().GetFullName()),
+				tracing.AttrWorkflowJobID.Int64(event.GetWorkflowJob().GetID()),
+			)
+			logger = logger.With().
+				Str("action", action).
+				Str("repo", event.GetRepo().GetFullName()).
+				Int64("workflow_job_id", event.GetWorkflowJob().GetID()).
+				Int64("run_id", event.GetWorkflowJob().GetRunID()).
+				Logger()
+			ctx = logger.WithContext(ctx)
+
+			if source != "" && !sourceConf.Allows(event.GetRepo().GetFullName()) {
+				logger.Warn().Msg("rejected workflow job of the repository not allowed for the webhook source")
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+
+			if err := controller.ReceiveWorkflowJobEvent(ctx, source, event); err != nil {
 				if errors.Is(err, service.ErrNonTargetEvent) {
 					logger.Debug().Err(err).Msg("received non target event, return OK")
 					w.WriteHeader(http.StatusAccepted)
 					return
 				}
 
+				if errors.Is(err, service.ErrQueued) {
+					logger.Info().Err(err).Msg("workflow job is queued by the concurrency limits")
+					w.WriteHeader(http.StatusAccepted)
+					return
+				}
+
+				if errors.Is(err, service.ErrShuttingDown) {
+					logger.Warn().Err(err).Msg("rejected event while shutting down")
+					w.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+
+				if errors.Is(err, service.ErrPermanent) {
+					logger.Error().Err(err).Msg("failed to dispatch workflow job, not retrying")
+					w.WriteHeader(http.StatusUnprocessableEntity)
+					return
+				}
+
 				if errors.Is(err, service.ErrBadRequest) {
 					logger.Warn().Err(err).Msg("received bad request")
 					w.WriteHeader(http.StatusBadRequest)
@@ -69,10 +163,55 @@ func HandleWebhookEvents(controller *service.Controller) func(http.ResponseWrite
 			w.WriteHeader(http.StatusOK)
 			return
 
+		case *github.InstallationEvent:
+			action = event.GetAction()
+			// the installations of the GitHub App are only tracked from its own webhook
+			if source != "" {
+				logger.Warn().Msgf("received installation event from webhook source(%s), return NotFound", source)
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			if err := controller.ReceiveInstallationEvent(ctx, event); err != nil {
+				logger.Warn().Err(err).Msg("received bad request")
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			w.WriteHeader(http.StatusOK)
+			return
+
+		case *github.InstallationRepositoriesEvent:
+			action = event.GetAction()
+			// the installations of the GitHub App are only tracked from its own webhook
+			if source != "" {
+				logger.Warn().Msgf("received installation_repositories event from webhook source(%s), return NotFound", source)
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			if err := controller.ReceiveInstallationRepositoriesEvent(ctx, event); err != nil {
+				logger.Warn().Err(err).Msg("received bad request")
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+
+			w.WriteHeader(http.StatusOK)
+			return
+
 		default:
-			logger.Warn().Msgf("received not register event(%s), return NotFound", payload)
+			logger.Warn().Msgf("received not register event(%s), return NotFound", eventType)
 			w.WriteHeader(http.StatusNotFound)
 			return
 		}
 	}
 }
+
+// statusRecorder records the status code written to the response for metrics
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	r.status = code
+	r.ResponseWriter.WriteHeader(code)
+}
@@ -0,0 +1,155 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Special fields recognized by Cloud Logging in structured logs
+// See: https://cloud.google.com/logging/docs/structured-logging#special-payload-fields
+const (
+	fieldSourceLocation = "logging.googleapis.com/sourceLocation"
+	fieldTrace          = "logging.googleapis.com/trace"
+	fieldSpanID         = "logging.googleapis.com/spanId"
+	fieldTraceSampled   = "logging.googleapis.com/trace_sampled"
+)
+
+// Setup configures the global logger to write Cloud Logging compatible JSON to w
+func Setup(w io.Writer, level string) error {
+	if err := SetLevel(level); err != nil {
+		return err
+	}
+
+	zerolog.LevelFieldName = "severity"
+	zerolog.LevelFieldMarshalFunc = severity
+	zerolog.TimestampFieldName = "time"
+	zerolog.TimeFieldFormat = time.RFC3339Nano
+
+	log.Logger = New(w)
+	// loggers taken by zerolog.Ctx from a context without a logger fall back to the global logger
+	zerolog.DefaultContextLogger = &log.Logger
+
+	return nil
+}
+
+// SetLevel changes the global log level
+func SetLevel(level string) error {
+	logLevel, err := zerolog.ParseLevel(level)
+	if err != nil {
+		return fmt.Errorf("failed to parse log level: %w", err)
+	}
+	zerolog.SetGlobalLevel(logLevel)
+
+	return nil
+}
+
+// New creates a logger writing Cloud Logging compatible JSON to w
+func New(w io.Writer) zerolog.Logger {
+	return zerolog.New(w).With().Timestamp().Logger().Hook(sourceLocationHook{})
+}
+
+// severity maps zerolog levels to Cloud Logging LogSeverity
+func severity(l zerolog.Level) string {
+	switch l {
+	case zerolog.TraceLevel, zerolog.DebugLevel:
+		return "DEBUG"
+	case zerolog.InfoLevel:
+		return "INFO"
+	case zerolog.WarnLevel:
+		return "WARNING"
+	case zerolog.ErrorLevel:
+		return "ERROR"
+	case zerolog.FatalLevel:
+		return "CRITICAL"
+	case zerolog.PanicLevel:
+		return "ALERT"
+	default:
+		return "DEFAULT"
+	}
+}
+
+// sourceLocationHook adds the location of the logging call as sourceLocation
+type sourceLocationHook struct{}
+
+func (sourceLocationHook) Run(e *zerolog.Event, _ zerolog.Level, _ string) {
+	pcs := make([]uintptr, 16)
+	n := runtime.Callers(3, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+	for {
+		frame, more := frames.Next()
+		if !strings.HasPrefix(frame.Function, "github.com/rs/zerolog") {
+			e.Dict(fieldSourceLocation, zerolog.Dict().
+				Str("file", frame.File).
+				Str("line", fmt.Sprint(frame.Line)).
+				Str("function", frame.Function))
+			return
+		}
+		if !more {
+			return
+		}
+	}
+}
+
+// WithTrace adds the fields to correlate logs with the trace of the span in ctx.
+// The trace from the X-Cloud-Trace-Context header is used if ctx does not have a valid span.
+func WithTrace(ctx context.Context, logger zerolog.Logger, project string, header http.Header) zerolog.Logger {
+	traceID, spanID, sampled := traceFromContext(ctx)
+	if traceID == "" {
+		traceID, spanID, sampled = traceFromHeader(header)
+	}
+	if traceID == "" {
+		return logger
+	}
+
+	c := logger.With().Bool(fieldTraceSampled, sampled)
+	if project != "" {
+		c = c.Str(fieldTrace, fmt.Sprintf("projects/%s/traces/%s", project, traceID))
+	} else {
+		c = c.Str(fieldTrace, traceID)
+	}
+	if spanID != "" {
+		c = c.Str(fieldSpanID, spanID)
+	}
+
+	return c.Logger()
+}
+
+func traceFromContext(ctx context.Context) (traceID, spanID string, sampled bool) {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return "", "", false
+	}
+
+	return sc.TraceID().String(), sc.SpanID().String(), sc.IsSampled()
+}
+
+// X-Cloud-Trace-Context: TRACE_ID/SPAN_ID;o=TRACE_TRUE
+var cloudTraceContext = regexp.MustCompile(`^([0-9a-fA-F]{32})(?:/([0-9]+))?(?:;o=([01]))?$`)
+
+func traceFromHeader(header http.Header) (traceID, spanID string, sampled bool) {
+	m := cloudTraceContext.FindStringSubmatch(header.Get("X-Cloud-Trace-Context"))
+	if m == nil {
+		return "", "", false
+	}
+
+	spanID = m[2]
+	if spanID != "" {
+		var id uint64
+		if _, err := fmt.Sscan(spanID, &id); err == nil {
+			// Cloud Logging expects the span ID in hex
+			spanID = fmt.Sprintf("%016x", id)
+		}
+	}
+
+	return m[1], spanID, m[3] == "1"
+}
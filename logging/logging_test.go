@@ -0,0 +1,98 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/rs/zerolog"
+)
+
+func TestSetup(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Setup(&buf, "debug"); err != nil {
+		t.Fatalf("Setup() error = %v", err)
+	}
+	t.Cleanup(func() { zerolog.SetGlobalLevel(zerolog.TraceLevel) })
+
+	logger := New(&buf)
+	logger.Warn().Msg("hello")
+
+	var got map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("failed to unmarshal log: %v, %s", err, buf.String())
+	}
+
+	if got["severity"] != "WARNING" {
+		t.Errorf("severity = %v, want WARNING", got["severity"])
+	}
+	if got["message"] != "hello" {
+		t.Errorf("message = %v, want hello", got["message"])
+	}
+	loc, ok := got[fieldSourceLocation].(map[string]any)
+	if !ok {
+		t.Fatalf("%s is not found: %s", fieldSourceLocation, buf.String())
+	}
+	if !strings.HasSuffix(loc["file"].(string), "logging_test.go") {
+		t.Errorf("sourceLocation.file = %v, want logging_test.go", loc["file"])
+	}
+	if !strings.HasSuffix(loc["function"].(string), "TestSetup") {
+		t.Errorf("sourceLocation.function = %v, want TestSetup", loc["function"])
+	}
+}
+
+func TestWithTrace(t *testing.T) {
+	tests := []struct {
+		want    map[string]any
+		name    string
+		project string
+		header  string
+	}{
+		{
+			name:    "cloud trace context",
+			project: "my-project",
+			header:  "105445aa7843bc8bf206b12000100000/1;o=1",
+			want: map[string]any{
+				fieldTrace:        "projects/my-project/traces/105445aa7843bc8bf206b12000100000",
+				fieldSpanID:       "0000000000000001",
+				fieldTraceSampled: true,
+			},
+		},
+		{
+			name:   "unknown project",
+			header: "105445aa7843bc8bf206b12000100000",
+			want: map[string]any{
+				fieldTrace:        "105445aa7843bc8bf206b12000100000",
+				fieldTraceSampled: false,
+			},
+		},
+		{
+			name:   "no trace",
+			header: "",
+			want:   map[string]any{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			header := http.Header{}
+			header.Set("X-Cloud-Trace-Context", tt.header)
+
+			logger := WithTrace(context.Background(), zerolog.New(&buf), tt.project, header)
+			logger.Log().Send()
+
+			var got map[string]any
+			if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+				t.Fatalf("failed to unmarshal log: %v", err)
+			}
+			if d := cmp.Diff(tt.want, got); d != "" {
+				t.Errorf("WithTrace() mismatch (-want +got):\n%s", d)
+			}
+		})
+	}
+}
@@ -2,44 +2,83 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 
+	"github.com/karahiyo/actions-job/adapter"
 	"github.com/karahiyo/actions-job/config"
 	"github.com/karahiyo/actions-job/handler"
+	"github.com/karahiyo/actions-job/logging"
+	"github.com/karahiyo/actions-job/metrics"
 	"github.com/karahiyo/actions-job/service"
-	"github.com/rs/zerolog"
+	"github.com/karahiyo/actions-job/tracing"
 	"github.com/rs/zerolog/log"
 )
 
 func init() {
-	zerolog.LevelFieldName = "severity"
-
 	var err error
 	cfg, err := config.Load()
 	if err != nil {
 		log.Fatal().Msgf("failed to load config: %v", err)
 	}
 
-	logLevel, err := zerolog.ParseLevel(cfg.ServerConfig.LogLevel)
-	if err != nil {
-		log.Fatal().Msgf("failed to parse log level: %v", err)
+	if err := logging.Setup(os.Stdout, cfg.ServerConfig.LogLevel); err != nil {
+		log.Fatal().Msgf("failed to setup logger: %v", err)
 	}
-	zerolog.SetGlobalLevel(logLevel)
+
+	if cfg.GCPConfig.ProjectID == "" {
+		// the project is only used for log correlation, so that ignore errors when running outside of Google Cloud
+		if meta, err := adapter.GetInstanceMetadata(context.Background()); err == nil {
+			config.SetFallbackProjectID(meta.ProjectID)
+		} else {
+			log.Debug().Err(err).Msg("failed to resolve project id from metadata server")
+		}
+	}
+
+	metrics.SetInstance(config.GetGCPConfig().ProjectID, config.GetGCPConfig().Region)
+
+	config.OnReload(func(c *config.Config) {
+		metrics.SetInstance(config.GetGCPConfig().ProjectID, c.GCPConfig.Region)
+		if err := logging.SetLevel(c.ServerConfig.LogLevel); err != nil {
+			log.Error().Err(err).Msg("failed to update log level")
+		}
+	})
 }
 
 func main() {
-	ctx := context.Background()
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+	ctx = log.Logger.WithContext(ctx)
 
 	log.Info().Msgf("starting HTTP server...")
 
+	shutdownTracing, err := tracing.Init(ctx, config.GetTracingConfig())
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to initialize tracing")
+	}
+
 	controller, err := service.NewController(ctx)
 	if err != nil {
 		log.Fatal().Err(err).Msg("failed to initialize controller")
 	}
+	if err := controller.VerifySingleInstance(ctx); err != nil {
+		log.Fatal().Err(err).Msg("failed to verify the instances of the controller")
+	}
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("/github/events", handler.HandleWebhookEvents(controller))
+	mux.HandleFunc("/github/events/", handler.HandleWebhookEvents(controller))
+	mux.Handle("/metrics", metrics.Handler())
+	mux.HandleFunc("/healthz", handler.HandleHealthz())
+	mux.HandleFunc("/readyz", handler.HandleReadyz(controller))
+	mux.HandleFunc("/debug/config", handler.HandleDebugConfig())
+	mux.HandleFunc("/debug/gc", handler.HandleDebugGC(controller))
+	mux.HandleFunc("/debug/executions/", handler.HandleDebugExecution(controller))
+	mux.HandleFunc("/debug/installations", handler.HandleDebugInstallations(controller))
 
 	srv := &http.Server{
 		Addr:         fmt.Sprintf(":%d", config.GetServerConfig().Port),
@@ -48,8 +87,47 @@ func main() {
 		WriteTimeout: config.GetServerConfig().DefaultTimeout,
 	}
 
-	log.Info().Msgf("HTTP server started: port = %d", config.GetServerConfig().Port)
-	if err := srv.ListenAndServe(); err != nil {
-		log.Fatal().Err(err).Msg("Startup failed")
+	go config.Watch(ctx)
+	go controller.RunQueue(ctx)
+	go controller.RunPools(ctx)
+	go controller.RunReconciler(ctx)
+	go controller.RunGC(ctx)
+	go controller.RunHookRanges(ctx)
+	go controller.RunInstallations(ctx)
+
+	go func() {
+		log.Info().Msgf("HTTP server started: port = %d", config.GetServerConfig().Port)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal().Err(err).Msg("Startup failed")
+		}
+	}()
+
+	go func() {
+		// not canceled by the signal, so that the replayed dispatches are drained on shutdown like webhook requests
+		if err := controller.ReplaySpooled(log.Logger.WithContext(context.Background())); err != nil {
+			log.Error().Err(err).Msg("failed to replay spooled events")
+		}
+	}()
+
+	<-ctx.Done()
+	stop()
+	log.Info().Msg("shutting down HTTP server...")
+
+	// ctx is already canceled, so that use a new context for the shutdown deadline
+	shutdownCtx, cancel := context.WithTimeout(log.Logger.WithContext(context.Background()), config.GetServerConfig().ShutdownTimeout)
+	defer cancel()
+
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Error().Err(err).Msg("failed to shutdown HTTP server gracefully")
 	}
+
+	if err := controller.Shutdown(shutdownCtx); err != nil {
+		log.Error().Err(err).Msg("failed to drain in-flight dispatches")
+	}
+
+	if err := shutdownTracing(context.Background()); err != nil {
+		log.Error().Err(err).Msg("failed to shutdown tracing")
+	}
+
+	log.Info().Msg("HTTP server stopped")
 }
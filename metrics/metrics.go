@@ -0,0 +1,183 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "actions_job"
+
+const (
+	OutcomeSuccess = "success"
+	OutcomeError   = "error"
+)
+
+var (
+	webhookEventsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "webhook_events_total",
+		Help:      "Number of received webhook events by event type, action and response status code.",
+	}, []string{"project", "region", "event", "action", "code"})
+
+	webhookSignaturesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "webhook_signatures_total",
+		Help:      "Number of webhook payload signatures by the position of the matched secret.",
+	}, []string{"project", "region", "secret"})
+
+	manifestDownloadDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "manifest_download_duration_seconds",
+		Help:      "Time taken to download job manifests from GitHub.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"project", "region", "outcome"})
+
+	manifestCacheRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "manifest_cache_requests_total",
+		Help:      "Number of job manifest lookups by cache result: hit, not_modified (revalidated by ETag) or miss.",
+	}, []string{"project", "region", "result"})
+
+	githubRateLimit = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "github_rate_limit",
+		Help:      "GitHub API rate limit of the installation by resource.",
+	}, []string{"project", "region", "resource"})
+
+	githubRateLimitRemaining = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "github_rate_limit_remaining",
+		Help:      "Remaining GitHub API requests of the installation by resource.",
+	}, []string{"project", "region", "resource"})
+
+	githubRateLimitReset = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "github_rate_limit_reset_timestamp_seconds",
+		Help:      "Unix time when the GitHub API rate limit of the installation resets by resource.",
+	}, []string{"project", "region", "resource"})
+
+	jobOperationDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "job_operation_duration_seconds",
+		Help:      "Time taken by Cloud Run Jobs operations while dispatching a job.",
+		Buckets:   []float64{.05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+	}, []string{"project", "region", "operation", "outcome"})
+
+	dispatchQueueLength = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "dispatch_queue_length",
+		Help:      "Number of workflow jobs waiting for capacity under the concurrency limits.",
+	}, []string{"project", "region"})
+
+	dispatchesInFlight = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "dispatches_in_flight",
+		Help:      "Number of job dispatches currently in progress.",
+	}, []string{"project", "region"})
+
+	reconciledJobsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "reconciled_jobs_total",
+		Help:      "Number of queued workflow jobs without dispatches found by the reconciler.",
+	}, []string{"project", "region", "outcome"})
+)
+
+// Cloud Run Jobs operations observed by ObserveJobOperation
+const (
+	OperationCreate    = "create"
+	OperationUpdate    = "update"
+	OperationWaitReady = "wait_ready"
+	OperationStart     = "start"
+)
+
+// Handler returns the HTTP handler serving metrics in the Prometheus exposition format
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// instance is the project and region of the controller, labeling the metrics not related to a dispatched job
+var instance atomic.Pointer[[2]string]
+
+// SetInstance sets the project and region the controller runs in
+func SetInstance(project, region string) {
+	instance.Store(&[2]string{project, region})
+}
+
+func instanceLabels() (string, string) {
+	if i := instance.Load(); i != nil {
+		return i[0], i[1]
+	}
+	return "", ""
+}
+
+func IncWebhookEvent(event, action string, code int) {
+	project, region := instanceLabels()
+	webhookEventsTotal.WithLabelValues(project, region, event, action, strconv.Itoa(code)).Inc()
+}
+
+// SecretUnmatched is the secret label for payloads that did not match any webhook secret
+const SecretUnmatched = "none"
+
+// IncWebhookSignature counts a validated payload signature by the position of the matched secret, e.g. "current"
+func IncWebhookSignature(secret string) {
+	project, region := instanceLabels()
+	webhookSignaturesTotal.WithLabelValues(project, region, secret).Inc()
+}
+
+func ObserveManifestDownload(project, region string, start time.Time, err error) {
+	manifestDownloadDuration.WithLabelValues(project, region, outcome(err)).Observe(time.Since(start).Seconds())
+}
+
+// Results of manifest cache lookups counted by IncManifestCache
+const (
+	CacheHit         = "hit"
+	CacheNotModified = "not_modified"
+	CacheMiss        = "miss"
+)
+
+func IncManifestCache(result string) {
+	project, region := instanceLabels()
+	manifestCacheRequestsTotal.WithLabelValues(project, region, result).Inc()
+}
+
+// SetGitHubRateLimit records the rate limit headers of a GitHub API response
+func SetGitHubRateLimit(resource string, limit, remaining int, reset int64) {
+	project, region := instanceLabels()
+	githubRateLimit.WithLabelValues(project, region, resource).Set(float64(limit))
+	githubRateLimitRemaining.WithLabelValues(project, region, resource).Set(float64(remaining))
+	githubRateLimitReset.WithLabelValues(project, region, resource).Set(float64(reset))
+}
+
+func ObserveJobOperation(project, region, operation string, start time.Time, err error) {
+	jobOperationDuration.WithLabelValues(project, region, operation, outcome(err)).Observe(time.Since(start).Seconds())
+}
+
+// TrackDispatch increments the in-flight dispatch gauge and returns a function to decrement it
+func TrackDispatch(project, region string) func() {
+	g := dispatchesInFlight.WithLabelValues(project, region)
+	g.Inc()
+	return g.Dec
+}
+
+func IncReconciledJob(err error) {
+	project, region := instanceLabels()
+	reconciledJobsTotal.WithLabelValues(project, region, outcome(err)).Inc()
+}
+
+func SetDispatchQueueLength(n int) {
+	project, region := instanceLabels()
+	dispatchQueueLength.WithLabelValues(project, region).Set(float64(n))
+}
+
+func outcome(err error) string {
+	if err != nil {
+		return OutcomeError
+	}
+	return OutcomeSuccess
+}
@@ -0,0 +1,127 @@
+package secret
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"google.golang.org/api/secretmanager/v1"
+)
+
+// Resolver resolves a secret reference to its value
+type Resolver interface {
+	Resolve(ctx context.Context, ref string) (string, error)
+}
+
+// Schemes of secret references
+const (
+	SchemeFile          = "file"
+	SchemeEnv           = "env"
+	SchemeSecretManager = "sm"
+)
+
+// SchemeResolver dispatches secret references of the form "<scheme>://<location>" to the resolver for the scheme.
+//
+//	file:///var/secrets/webhook-secret
+//	env://WEBHOOK_SECRET
+//	sm://projects/my-project/secrets/webhook-secret/versions/latest
+type SchemeResolver map[string]Resolver
+
+// NewDefaultResolver returns a resolver supporting file, env and Secret Manager references
+func NewDefaultResolver() SchemeResolver {
+	return SchemeResolver{
+		SchemeFile:          FileResolver{},
+		SchemeEnv:           EnvResolver{},
+		SchemeSecretManager: &SecretManagerResolver{},
+	}
+}
+
+func (s SchemeResolver) Resolve(ctx context.Context, ref string) (string, error) {
+	scheme, location, ok := strings.Cut(ref, "://")
+	if !ok {
+		return "", fmt.Errorf("invalid secret reference, must be <scheme>://<location>: %s", ref)
+	}
+
+	r, ok := s[scheme]
+	if !ok {
+		return "", fmt.Errorf("unsupported secret reference scheme: %s", scheme)
+	}
+
+	return r.Resolve(ctx, location)
+}
+
+// FileResolver reads the secret from a file, e.g. a mounted volume.
+// Leading and trailing whitespace is trimmed.
+type FileResolver struct{}
+
+func (FileResolver) Resolve(_ context.Context, path string) (string, error) {
+	b, err := os.ReadFile(path) // nolint:gosec
+	if err != nil {
+		return "", fmt.Errorf("failed to read secret file: path=%s, %w", path, err)
+	}
+
+	return strings.TrimSpace(string(b)), nil
+}
+
+// EnvResolver reads the secret from an environment variable
+type EnvResolver struct{}
+
+func (EnvResolver) Resolve(_ context.Context, name string) (string, error) {
+	v, ok := os.LookupEnv(name)
+	if !ok {
+		return "", fmt.Errorf("secret environment variable is not set: name=%s", name)
+	}
+
+	return v, nil
+}
+
+// SecretManagerResolver accesses a secret version in Secret Manager.
+// The latest version is used if the reference does not have a version.
+type SecretManagerResolver struct {
+	api *secretmanager.Service
+	mu  sync.Mutex
+}
+
+func (r *SecretManagerResolver) Resolve(ctx context.Context, name string) (string, error) {
+	api, err := r.service()
+	if err != nil {
+		return "", err
+	}
+
+	if !strings.Contains(name, "/versions/") {
+		name += "/versions/latest"
+	}
+
+	res, err := api.Projects.Secrets.Versions.Access(name).Context(ctx).Do()
+	if err != nil {
+		return "", fmt.Errorf("failed to access secret version: name=%s, %w", name, err)
+	}
+
+	data, err := base64.StdEncoding.DecodeString(res.Payload.Data)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode secret payload: name=%s, %w", name, err)
+	}
+
+	return string(data), nil
+}
+
+func (r *SecretManagerResolver) service() (*secretmanager.Service, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.api != nil {
+		return r.api, nil
+	}
+
+	// the service outlives the request, so that its credentials must not be bound to the request context
+	api, err := secretmanager.NewService(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize secret manager api: %w", err)
+	}
+	r.api = api
+
+	return api, nil
+}
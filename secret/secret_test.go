@@ -0,0 +1,47 @@
+package secret
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSchemeResolver_Resolve(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "webhook-secret")
+	if err := os.WriteFile(path, []byte("from-file\n"), 0o600); err != nil {
+		t.Fatalf("failed to write secret file: %v", err)
+	}
+	t.Setenv("TEST_SECRET", "from-env")
+
+	r := SchemeResolver{
+		SchemeFile: FileResolver{},
+		SchemeEnv:  EnvResolver{},
+	}
+
+	tests := []struct {
+		name    string
+		ref     string
+		want    string
+		wantErr bool
+	}{
+		{name: "file", ref: "file://" + path, want: "from-file"},
+		{name: "env", ref: "env://TEST_SECRET", want: "from-env"},
+		{name: "missing file", ref: "file://" + path + ".missing", wantErr: true},
+		{name: "unset env", ref: "env://TEST_SECRET_UNSET", wantErr: true},
+		{name: "unsupported scheme", ref: "vault://secret/webhook", wantErr: true},
+		{name: "raw value", ref: "not-a-reference", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := r.Resolve(context.Background(), tt.ref)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Resolve() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Resolve() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package service
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// dispatchBatch is the workflow jobs with the same labels dispatched as one execution with a task per workflow job
+type dispatchBatch struct {
+	reqs []dispatchRequest
+	// full is closed when the batch reaches the max size
+	full chan struct{}
+}
+
+// dispatchBatcher collects the dispatch requests arriving within a window into batches
+type dispatchBatcher struct {
+	batches map[string]*dispatchBatch
+	mu      sync.Mutex
+}
+
+func newDispatchBatcher() *dispatchBatcher {
+	return &dispatchBatcher{batches: make(map[string]*dispatchBatch)}
+}
+
+// batchKey identifies the requests that can share an execution: the same manifest at the same ref with the same labels,
+// received from the same source
+func batchKey(req dispatchRequest) string {
+	labels := make([]string, len(req.labels))
+	for i, l := range req.labels {
+		labels[i] = strings.ToLower(l)
+	}
+	sort.Strings(labels)
+
+	return req.source + ":" + req.owner + "/" + req.repo + "@" + req.ref + ":" + strings.Join(labels, ",")
+}
+
+// add adds req to the open batch with the same key, or opens a new one, and returns without waiting for the dispatch.
+// The batch is dispatched with dispatch in the background after window or once it has maxSize requests,
+// then done is called with the result for each request of the batch. ctx must not be canceled with the request of req.
+func (b *dispatchBatcher) add(ctx context.Context, req dispatchRequest, window time.Duration, maxSize int,
+	dispatch func(context.Context, dispatchRequest) (*dispatchedExecution, error),
+	done func(dispatchRequest, *dispatchedExecution, error),
+) {
+	key := batchKey(req)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if batch, ok := b.batches[key]; ok {
+		batch.reqs = append(batch.reqs, req)
+		if len(batch.reqs) >= maxSize {
+			// later requests open a new batch
+			delete(b.batches, key)
+			close(batch.full)
+		}
+		return
+	}
+
+	batch := &dispatchBatch{reqs: []dispatchRequest{req}, full: make(chan struct{})}
+	if maxSize > 1 {
+		b.batches[key] = batch
+	} else {
+		close(batch.full)
+	}
+
+	go func() {
+		select {
+		case <-time.After(window):
+		case <-batch.full:
+		}
+
+		b.mu.Lock()
+		if b.batches[key] == batch {
+			delete(b.batches, key)
+		}
+		// no more requests join the batch
+		reqs := batch.reqs
+		b.mu.Unlock()
+
+		batched := req
+		batched.tasks = len(reqs)
+		if len(reqs) > 1 {
+			zerolog.Ctx(ctx).Info().Msgf("dispatching %d workflow jobs in one execution", len(reqs))
+		}
+
+		execution, err := dispatch(ctx, batched)
+		for _, r := range reqs {
+			done(r, execution, err)
+		}
+	}()
+}
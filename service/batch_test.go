@@ -0,0 +1,52 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDispatchBatcher(t *testing.T) {
+	b := newDispatchBatcher()
+
+	var mu sync.Mutex
+	var dispatched []dispatchRequest
+	dispatch := func(_ context.Context, req dispatchRequest) (*dispatchedExecution, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		dispatched = append(dispatched, req)
+		return &dispatchedExecution{name: "execution"}, nil
+	}
+
+	labels := []string{"self-hosted", "job-manifest=job.yaml"}
+	start := time.Now()
+	var wg sync.WaitGroup
+	wg.Add(5)
+	done := func(dispatchRequest, *dispatchedExecution, error) {
+		wg.Done()
+	}
+	for i := 0; i < 5; i++ {
+		req := dispatchRequest{owner: "org", repo: "a", ref: "sha", labels: labels}
+		if i == 4 {
+			req.labels = []string{"self-hosted", "job-manifest=other.yaml"}
+		}
+		b.add(context.Background(), req, 200*time.Millisecond, 3, dispatch, done)
+	}
+	if elapsed := time.Since(start); elapsed >= 200*time.Millisecond {
+		t.Errorf("add() took %s, want to return without waiting for the window", elapsed)
+	}
+	wg.Wait()
+
+	// 4 requests for job.yaml are split by the max size, and other.yaml has its own batch
+	tasks := make(map[string][]int)
+	for _, req := range dispatched {
+		tasks[req.labels[1]] = append(tasks[req.labels[1]], req.tasks)
+	}
+	if got := tasks["job-manifest=job.yaml"]; len(got) != 2 || got[0]+got[1] != 4 {
+		t.Errorf("tasks of job.yaml = %v, want 4 tasks in 2 executions", got)
+	}
+	if got := tasks["job-manifest=other.yaml"]; len(got) != 1 || got[0] != 1 {
+		t.Errorf("tasks of other.yaml = %v, want 1 task", got)
+	}
+}
@@ -5,55 +5,128 @@ import (
 	"errors"
 	"fmt"
 	"strings"
+	"sync"
+	"time"
 
-	"github.com/davecgh/go-spew/spew"
 	"github.com/go-playground/validator/v10"
 	"github.com/google/go-github/v52/github"
 	"github.com/karahiyo/actions-job/adapter"
 	"github.com/karahiyo/actions-job/config"
+	"github.com/karahiyo/actions-job/metrics"
+	"github.com/karahiyo/actions-job/tracing"
 	"github.com/rs/zerolog"
-	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/api/run/v1"
 	k8syaml "sigs.k8s.io/yaml"
 )
 
 type Controller struct {
-	ghAdapter adapter.GitHubAdapter
-	validate  *validator.Validate
+	ghAdapter     adapter.GitHubAdapter
+	ghConfig      config.GitHubAppConfig
+	validate      *validator.Validate
+	spool         *spool
+	inflight      inflightDispatches
+	limiter       *concurrencyLimiter
+	readiness     readinessCache
+	reporter      failureReporter
+	executions    executionLinks
+	installations installationRegistry
+	hookRanges    hookRanges
+	pools         *warmPools
+	dispatched    *dispatchRecords
+	batcher       *dispatchBatcher
+	jobLocks      *jobLocks
+	// newJobsAdapter returns the adapter for a project and region. It is replaceable for tests.
+	newJobsAdapter func(ctx context.Context, project, region string) (adapter.JobsAdapter, error)
+	// newGitHubAdapter returns the adapter for a GitHub App installation. It is replaceable for tests.
+	newGitHubAdapter func(conf config.GitHubAppConfig) (adapter.GitHubAdapter, error)
+	// getMaxInstances returns the max instances of a Cloud Run service. It is replaceable for tests.
+	getMaxInstances func(ctx context.Context, project, region, name string) (int, error)
+	sourceAdapters  map[string]sourceAdapter
+	ghMu            sync.RWMutex
 }
 
 var (
 	ErrNonTargetEvent = fmt.Errorf("non target event")
 	ErrBadRequest     = fmt.Errorf("bad request")
+	ErrShuttingDown   = fmt.Errorf("shutting down")
+	ErrQueued         = fmt.Errorf("queued")
+	// ErrPermanent is a dispatch failure which fails again on retry, e.g. a missing manifest or a permission denied
+	ErrPermanent = fmt.Errorf("permanent failure")
 )
 
 func NewController(ctx context.Context) (*Controller, error) {
-	ghAdapter, err := adapter.NewGitHubAdapter(config.GetGitHubAppConfig())
+	ghConfig := config.GetGitHubAppConfig()
+	ghAdapter, err := adapter.NewGitHubAdapter(ghConfig)
 	if err != nil {
 		return nil, fmt.Errorf("failed to initialize github client: %w", err)
 	}
 
-	return &Controller{
-		ghAdapter: ghAdapter,
-		validate:  validator.New(),
-	}, nil
+	c := &Controller{
+		ghAdapter:  ghAdapter,
+		ghConfig:   ghConfig,
+		validate:   validator.New(),
+		spool:      newSpool(config.GetServerConfig().SpoolDir),
+		limiter:    newConcurrencyLimiter(),
+		pools:      newWarmPools(),
+		dispatched: newDispatchRecords(),
+		batcher:    newDispatchBatcher(),
+		jobLocks:   newJobLocks(),
+
+		newJobsAdapter:   newJobsAdapterCache().get,
+		newGitHubAdapter: adapter.NewGitHubAdapter,
+		getMaxInstances:  adapter.GetServiceMaxInstances,
+	}
+	config.OnReload(func(conf *config.Config) {
+		if err := c.reloadGitHubAdapter(conf.GitHubAppConfig); err != nil {
+			zerolog.Ctx(ctx).Error().Err(err).Msg("failed to reload github client, keeping the current one")
+		}
+	})
+
+	return c, nil
 }
 
-func (c *Controller) ReceiveWorkflowJobEvent(ctx context.Context, event *github.WorkflowJobEvent) error {
-	logger := zerolog.Ctx(ctx)
+// ReceiveWorkflowJobEvent dispatches a runner for the queued workflow job event received from source,
+// which is empty for the events of the GitHub App
+func (c *Controller) ReceiveWorkflowJobEvent(ctx context.Context, source string, event *github.WorkflowJobEvent) error {
+	ctx, span := tracing.Start(ctx, "Controller.ReceiveWorkflowJobEvent",
+		tracing.AttrRepository.String(event.GetRepo().GetFullName()),
+		tracing.AttrWorkflowJobID.Int64(event.GetWorkflowJob().GetID()),
+	)
+	err := c.receiveWorkflowJobEvent(ctx, source, event)
+	if event.GetAction() == "queued" && isReportedFailure(err) {
+		c.reportFailure(ctx, source, event, err)
+	}
+	tracing.End(span, err)
+
+	return err
+}
 
+func (c *Controller) receiveWorkflowJobEvent(ctx context.Context, source string, event *github.WorkflowJobEvent) error {
 	if !event.GetRepo().GetPrivate() {
-		return fmt.Errorf("skipped. using self-hosted runner with public repositories is a security vulnerability: %w", ErrBadRequest)
+		return fmt.Errorf("skipped. using self-hosted runner with public repositories is a security vulnerability: %w, %w", errRepositoryNotAllowed, ErrBadRequest)
 	}
 
 	if event.GetRepo().GetFork() {
-		return fmt.Errorf("skipped. using self-hosted runner with forked repositories is a security vulnerability: %w", ErrBadRequest)
+		return fmt.Errorf("skipped. using self-hosted runner with forked repositories is a security vulnerability: %w, %w", errRepositoryNotAllowed, ErrBadRequest)
+	}
+
+	if event.GetAction() == "completed" {
+		return c.receiveCompletedEvent(ctx, event)
+	}
+
+	if event.GetAction() == "in_progress" {
+		return c.receiveInProgressEvent(ctx, event)
 	}
 
 	if event.GetAction() != "queued" {
 		return fmt.Errorf("event is not \"queued\" action: %w", ErrNonTargetEvent)
 	}
 
+	if source == "" && c.installations.suspended(event.GetInstallation().GetID()) {
+		return fmt.Errorf("installation is suspended: installation_id=%d, %w", event.GetInstallation().GetID(), ErrNonTargetEvent)
+	}
+
 	ownerRepo := strings.Split(event.GetRepo().GetFullName(), "/")
 	owner := ownerRepo[0]
 	repo := ownerRepo[1]
@@ -63,6 +136,23 @@ func (c *Controller) ReceiveWorkflowJobEvent(ctx context.Context, event *github.
 		return fmt.Errorf("label \"self-hosted\" is not found in labels: %w", ErrNonTargetEvent)
 	}
 
+	if err := c.inflight.begin(source, event); err != nil {
+		return err
+	}
+	// the dispatches in the background end themselves
+	async := false
+	defer func() {
+		if !async {
+			c.inflight.end(event)
+		}
+	}()
+
+	// the workflow job may be delivered more than once, e.g. redelivered or sent by the webhooks of several sources.
+	// It is checked while in flight, so that the concurrent deliveries are rejected by begin.
+	if id := event.GetWorkflowJob().GetID(); c.dispatched.has(id) || c.limiter.tracks(id) {
+		return fmt.Errorf("workflow job is already dispatched or queued: %w", ErrNonTargetEvent)
+	}
+
 	labeledOpts := getOptionsFromLabels(labels)
 	if err := c.validate.Struct(labeledOpts); err != nil {
 		var ve validator.ValidationErrors
@@ -73,34 +163,136 @@ func (c *Controller) ReceiveWorkflowJobEvent(ctx context.Context, event *github.
 		return fmt.Errorf("validation error: err = %w", err)
 	}
 
-	// default use current commit SHA as ref.
-	// TODO: support manual specification of ref
-	ref := event.GetWorkflowJob().GetHeadSHA()
-
-	runnerManifest, err := c.ghAdapter.DownloadContent(ctx, owner, repo, labeledOpts.jobManifest, ref)
-	if err != nil {
-		return fmt.Errorf("failed to download actions runner config: %w", err)
+	if _, err := resolveResources(labeledOpts, config.GetSizesConfig()); err != nil {
+		return fmt.Errorf("invalid resource labels: %w, %w, %w", err, errInvalidLabels, ErrBadRequest)
 	}
-	logger.Debug().Msgf("runner config yaml: %s", runnerManifest)
 
-	job, err := parseJobManifest([]byte(runnerManifest))
-	if err != nil {
-		return fmt.Errorf("failed to parse job manifest: manifest=%s, %w", runnerManifest, err)
+	if pool, ok := c.pools.claim(event.GetWorkflowJob().GetID(), event.GetRepo().GetFullName(), labels, time.Now()); ok {
+		c.dispatched.record(event.GetWorkflowJob().GetID(), time.Now())
+		zerolog.Ctx(ctx).Info().Str("pool", pool).Msg("workflow job is expected to be picked up by an idle runner in the pool")
+		c.pools.wake()
+		return nil
 	}
 
-	jobName := job.Metadata.Name
-	job = updateJobManifest(job, jobEnvs{
+	req := dispatchRequest{
+		source: source,
+		event:  event,
 		owner:  owner,
 		repo:   repo,
-		labels: labels,
-	})
+		// use the commit SHA of the workflow run as ref
+		ref:         event.GetWorkflowJob().GetHeadSHA(),
+		labels:      labels,
+		labeledOpts: labeledOpts,
+	}
+	if !c.limiter.acquireOrEnqueue(req, time.Now()) {
+		c.dispatched.record(event.GetWorkflowJob().GetID(), time.Now())
+		return fmt.Errorf("concurrency limit reached, waiting in the queue: %w", ErrQueued)
+	}
+
+	if config.GetServerConfig().BatchWindow > 0 {
+		// not to hold the webhook response for the window
+		async = true
+		c.dispatchAsync(req)
+		return fmt.Errorf("dispatching in a batch: %w", ErrQueued)
+	}
+
+	execution, err := c.dispatch(ctx, req)
+	if err != nil {
+		var limitErr *adapter.RateLimitError
+		if errors.As(err, &limitErr) {
+			c.limiter.requeue(req, limitErr.Reset)
+			return fmt.Errorf("deferred until the github api rate limit resets at %s: %w", limitErr.Reset.Format(time.RFC3339), ErrQueued)
+		}
+
+		c.limiter.release(req.jobID())
+		if adapter.IsPermanent(err) {
+			return fmt.Errorf("%w, %w", err, ErrPermanent)
+		}
+		return err
+	}
+	c.dispatched.record(event.GetWorkflowJob().GetID(), time.Now())
+	c.logExecution(ctx, c.executions.record(event, *execution, time.Now()))
+
+	return nil
+}
+
+// receiveCompletedEvent frees the capacity used by the completed workflow job, and dispatches the queued workflow jobs
+func (c *Controller) receiveCompletedEvent(ctx context.Context, event *github.WorkflowJobEvent) error {
+	labels := event.GetWorkflowJob().Labels
+	if !includeSelfHostedLabel(labels) {
+		return fmt.Errorf("label \"self-hosted\" is not found in labels: %w", ErrNonTargetEvent)
+	}
+
+	labeledOpts := getOptionsFromLabels(labels)
+	if labeledOpts.jobManifest == "" {
+		return fmt.Errorf("label \"job-manifest\" is not found in labels: %w", ErrNonTargetEvent)
+	}
+
+	if c.pools.complete(event.GetWorkflowJob().GetID()) {
+		// the workflow job claimed a runner in the pools and did not acquire a lease
+		return nil
+	}
+
+	// the workflow job may have been cancelled while waiting in the queue
+	c.limiter.release(event.GetWorkflowJob().GetID())
+	c.dispatchQueued(ctx)
+
+	return nil
+}
+
+// logExecution logs the links to the execution started for the workflow job
+func (c *Controller) logExecution(ctx context.Context, link ExecutionLink) {
+	zerolog.Ctx(ctx).Info().
+		Str("execution", link.Execution).
+		Str("execution_url", link.ExecutionURL).
+		Str("logs_url", link.LogsURL).
+		Msg("started job execution for the workflow job")
+}
+
+// dispatchRequest is a queued workflow job to start a runner for
+type dispatchRequest struct {
+	// source is the webhook source of event, whose credentials are used to download the job manifest
+	source string
+	event  *github.WorkflowJobEvent
+	owner  string
+	repo   string
+	// ref is the git ref to download the job manifest from
+	ref string
+	// tasks is the number of runners to start in the execution, one per batched workflow job
+	tasks       int
+	labeledOpts labeledOptions
+	labels      []string
+	// notBefore is when the queued request may be dispatched, e.g. the reset of the GitHub API rate limit
+	notBefore time.Time
+}
+
+// dispatchedExecution is a job execution started by dispatch.
+// The runner registered by the execution is named after the execution.
+type dispatchedExecution struct {
+	project string
+	region  string
+	name    string
+}
+
+func (r dispatchRequest) key() limitKey {
+	return limitKey{owner: r.owner, repo: r.repo, manifest: r.labeledOpts.jobManifest}
+}
+
+func (r dispatchRequest) jobID() int64 {
+	return r.event.GetWorkflowJob().GetID()
+}
+
+// dispatch downloads the job manifest and starts a job execution for req
+func (c *Controller) dispatch(ctx context.Context, req dispatchRequest) (*dispatchedExecution, error) {
+	logger := zerolog.Ctx(ctx)
+	owner, repo, labeledOpts := req.owner, req.repo, req.labeledOpts
 
 	project := labeledOpts.project
 	region := labeledOpts.region
 	if project == "" || region == "" {
 		instanceMeta, err := adapter.GetInstanceMetadata(ctx)
 		if err != nil {
-			return fmt.Errorf("failed to get instance metadata: %w", err)
+			return nil, fmt.Errorf("failed to get instance metadata: %w", err)
 		}
 
 		if project == "" {
@@ -112,62 +304,218 @@ func (c *Controller) ReceiveWorkflowJobEvent(ctx context.Context, event *github.
 		}
 	}
 
-	if err := c.dispatchJobTransaction(ctx, project, region, jobName, job); err != nil {
-		return fmt.Errorf("failed to dispatch job: %w", err)
+	retry := newRetryPolicy(config.GetServerConfig())
+
+	gh, err := c.gitHubFor(req.source, req.event.GetInstallation().GetID())
+	if err != nil {
+		return nil, err
 	}
 
-	return nil
+	var runnerManifest string
+	downloadStart := time.Now()
+	err = retry.do(ctx, "download manifest", func() error {
+		var err error
+		runnerManifest, err = gh.DownloadContent(ctx, owner, repo, labeledOpts.jobManifest, req.ref)
+		return err
+	})
+	metrics.ObserveManifestDownload(project, region, downloadStart, err)
+	if errors.Is(err, adapter.ErrNotFound) {
+		return nil, fmt.Errorf("failed to download actions runner config: %w, %w", err, errManifestNotFound)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to download actions runner config: %w", err)
+	}
+	logger.Debug().Msgf("runner config yaml: %s", runnerManifest)
+
+	job, err := parseJobManifest([]byte(runnerManifest))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse job manifest: manifest=%s, %w, %w, %w", runnerManifest, err, errInvalidManifest, ErrPermanent)
+	}
+
+	// the ACCESS_TOKEN of the job manifest may not be allowed to register runners to the repositories of the
+	// other webhook sources, so that their runners register with a token created with the credentials of the source
+	var runnerToken string
+	if gh != c.gitHub() {
+		err = retry.do(ctx, "create registration token", func() error {
+			var err error
+			runnerToken, err = gh.CreateRegistrationToken(ctx, owner, repo)
+			return err
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create runner registration token: %w", err)
+		}
+	}
+
+	jobName := job.Metadata.Name
+	ghConfig := config.GetGitHubAppConfig()
+	job = updateJobManifest(job, jobEnvs{
+		owner:  owner,
+		repo:   repo,
+		labels: req.labels,
+		apiURL: ghConfig.APIBaseURL(),
+		webURL: ghConfig.WebBaseURL(),
+	})
+
+	resources, err := resolveResources(labeledOpts, config.GetSizesConfig())
+	if err != nil {
+		return nil, fmt.Errorf("invalid resource labels: %w, %w, %w", err, errInvalidLabels, ErrPermanent)
+	}
+	if err := applyResources(job, resources); err != nil {
+		return nil, fmt.Errorf("invalid resources of job manifest: manifest=%s, %w, %w, %w", labeledOpts.jobManifest, err, errInvalidManifest, ErrPermanent)
+	}
+
+	execution, err := c.dispatchJobTransaction(ctx, project, region, jobName, job, executionOverrides(job, req.tasks, runnerToken))
+	if err != nil {
+		return nil, fmt.Errorf("failed to dispatch job: %w", err)
+	}
+
+	return &dispatchedExecution{project: project, region: region, name: execution.Metadata.Name}, nil
 }
 
-// dispatchJobTransaction Create/Update Cloud Run Jobs and start a job execution
-func (c *Controller) dispatchJobTransaction(ctx context.Context, project, region, jobName string, job *run.Job) error {
+// dispatchJobTransaction Create/Update Cloud Run Jobs and start a job execution with the overrides
+func (c *Controller) dispatchJobTransaction(ctx context.Context, project, region, jobName string, job *run.Job, overrides *run.Overrides) (*run.Execution, error) {
 	logger := zerolog.Ctx(ctx)
 	var err error
 
-	jobsAdapter, err := adapter.NewJobsAdapter(ctx, project, region)
+	defer metrics.TrackDispatch(project, region)()
+
+	span := trace.SpanFromContext(ctx)
+	span.SetAttributes(
+		tracing.AttrProject.String(project),
+		tracing.AttrRegion.String(region),
+		tracing.AttrJobName.String(jobName),
+	)
+
+	jobsAdapter, err := c.newJobsAdapter(ctx, project, region)
 	if err != nil {
-		return fmt.Errorf("failed to initialize jobs client: %w", err)
+		return nil, fmt.Errorf("failed to initialize jobs client: %w", err)
 	}
 
-	exists, err := jobsAdapter.GetJob(ctx, jobName)
-	if err != nil && !errors.Is(err, adapter.ErrJobNotFound) {
-		return fmt.Errorf("failed to check job exists: %w", err)
-	}
+	// the execution must start with the job spec updated by this dispatch
+	defer c.jobLocks.lock(project, region, jobName)()
 
-	if exists == nil {
-		logger.Info().Msgf("job does not exists. creating new job...")
+	retry := newRetryPolicy(config.GetServerConfig())
 
-		created, err := jobsAdapter.CreateJob(ctx, job)
-		if err != nil {
-			return fmt.Errorf("failed to create job: %w", err)
+	var exists *run.Job
+	err = retry.do(ctx, "get job", func() error {
+		var err error
+		exists, err = jobsAdapter.GetJob(ctx, jobName)
+		if errors.Is(err, adapter.ErrJobNotFound) {
+			return nil
 		}
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to check job exists: %w", err)
+	}
 
-		logger.Info().Msgf("success to create a new job: %s", spew.Sdump(created))
-	} else {
-		log.Info().Msgf("job already exists. updating job...")
+	update := exists != nil
+	if !update {
+		logger.Info().Msgf("job does not exists. creating new job: name=%s", jobName)
+
+		var created *run.Job
+		var conflict bool
+		start := time.Now()
+		err := retry.do(ctx, "create job", func() error {
+			var err error
+			created, err = jobsAdapter.CreateJob(ctx, job)
+			if errors.Is(err, adapter.ErrConflict) {
+				// the job exists, so that creating it again conflicts as well
+				conflict = true
+				return nil
+			}
+			return err
+		})
+		metrics.ObserveJobOperation(project, region, metrics.OperationCreate, start, err)
+		switch {
+		case conflict:
+			// created by another dispatch meanwhile
+			logger.Info().Msgf("job was created concurrently. updating job: name=%s", jobName)
+			update = true
+		case err != nil:
+			return nil, fmt.Errorf("failed to create job: %w", err)
+		default:
+			logger.Info().Str("resource_version", created.Metadata.ResourceVersion).Msgf("success to create a new job: name=%s", jobName)
+		}
+	}
+
+	if update {
+		logger.Info().Msgf("job already exists. updating job: name=%s", jobName)
 		// TODO: check need to update current job
 
-		updated, err := jobsAdapter.UpdateJob(ctx, jobName, job)
+		var updated *run.Job
+		current := exists
+		start := time.Now()
+		err := retry.do(ctx, "update job", func() error {
+			if current == nil {
+				var err error
+				if current, err = jobsAdapter.GetJob(ctx, jobName); err != nil {
+					return err
+				}
+			}
+
+			// replace the job only if it is not modified since it was read
+			job.Metadata.ResourceVersion = current.Metadata.ResourceVersion
+
+			var err error
+			updated, err = jobsAdapter.UpdateJob(ctx, jobName, job)
+			if errors.Is(err, adapter.ErrConflict) {
+				// read the job again on retry
+				current = nil
+			}
+			return err
+		})
+		metrics.ObserveJobOperation(project, region, metrics.OperationUpdate, start, err)
 		if err != nil {
-			return fmt.Errorf("failed to update job: job=%s, %w", spew.Sdump(job), err)
+			return nil, fmt.Errorf("failed to update job: name=%s, %w", jobName, err)
 		}
 
-		logger.Info().Msgf("success to update the job: %s", spew.Sdump(updated))
+		logger.Info().Str("resource_version", updated.Metadata.ResourceVersion).Msgf("success to update the job: name=%s", jobName)
 	}
 
-	_, err = jobsAdapter.WaitJobReady(ctx, jobName)
+	start := time.Now()
+	err = retry.do(ctx, "wait job ready", func() error {
+		_, err := jobsAdapter.WaitJobReady(ctx, jobName)
+		return err
+	})
+	metrics.ObserveJobOperation(project, region, metrics.OperationWaitReady, start, err)
 	if err != nil {
-		return fmt.Errorf("failed to wait job ready: %w", err)
+		return nil, fmt.Errorf("failed to wait job ready: %w", err)
 	}
 
-	newExecution, err := jobsAdapter.StartJob(ctx, jobName)
+	// starting the job is not retried, since a failure such as a timeout or a 5xx may have started an execution anyway,
+	// and a retry would start a second runner for the workflow job
+	start = time.Now()
+	newExecution, err := jobsAdapter.StartJob(ctx, jobName, overrides)
+	metrics.ObserveJobOperation(project, region, metrics.OperationStart, start, err)
 	if err != nil {
-		return fmt.Errorf("failed to start job: %w", err)
+		return nil, fmt.Errorf("failed to start job: %w", err)
 	}
 
-	logger.Info().Msgf("success to start job: %v", spew.Sdump(newExecution))
+	logger.Info().Str("execution", newExecution.Metadata.Name).Msgf("success to start job: name=%s", jobName)
 
-	return nil
+	return newExecution, nil
+}
+
+// executionOverrides returns the overrides of the execution of a dispatch, nil to start the execution with the job spec.
+// The job spec is shared by the dispatches of the manifest and persisted, so that the task count of a batch and
+// the registration token of the runner are set per execution.
+func executionOverrides(job *run.Job, tasks int, runnerToken string) *run.Overrides {
+	var overrides run.Overrides
+	if tasks > 1 {
+		overrides.TaskCount = int64(tasks)
+	}
+	if runnerToken != "" {
+		overrides.ContainerOverrides = []*run.ContainerOverride{{
+			Name: job.Spec.Template.Spec.Template.Spec.Containers[0].Name,
+			Env:  []*run.EnvVar{{Name: "RUNNER_TOKEN", Value: runnerToken}},
+		}}
+	}
+
+	if overrides.TaskCount == 0 && len(overrides.ContainerOverrides) == 0 {
+		return nil
+	}
+	return &overrides
 }
 
 // includeSelfHostedLabel check if label "self-hosted" is included in labels
@@ -184,6 +532,10 @@ type labeledOptions struct {
 	project     string
 	region      string
 	jobManifest string `required:"true"`
+	// size is a name in config.SizesConfig, and cpu and memory override its resources
+	size   string
+	cpu    string
+	memory string
 }
 
 func getOptionsFromLabels(labels []string) labeledOptions {
@@ -202,6 +554,12 @@ func getOptionsFromLabels(labels []string) labeledOptions {
 			opts.region = kv[1]
 		case "job-manifest":
 			opts.jobManifest = kv[1]
+		case "size":
+			opts.size = kv[1]
+		case "cpu":
+			opts.cpu = kv[1]
+		case "memory":
+			opts.memory = kv[1]
 		default:
 			continue
 		}
@@ -223,6 +581,9 @@ type jobEnvs struct {
 	owner  string
 	repo   string
 	labels []string
+	// apiURL and webURL are the GitHub endpoints the runner registers with
+	apiURL string
+	webURL string
 }
 
 func updateJobManifest(job *run.Job, opts jobEnvs) *run.Job {
@@ -231,8 +592,15 @@ func updateJobManifest(job *run.Job, opts jobEnvs) *run.Job {
 	envs = append(envs, &run.EnvVar{Name: "OWNER", Value: opts.owner})
 	envs = append(envs, &run.EnvVar{Name: "REPO", Value: opts.repo})
 	envs = append(envs, &run.EnvVar{Name: "LABELS", Value: strings.Join(opts.labels, ",")})
+	envs = append(envs, &run.EnvVar{Name: "GITHUB_API_URL", Value: opts.apiURL})
+	envs = append(envs, &run.EnvVar{Name: "GITHUB_URL", Value: opts.webURL})
 
 	job.Spec.Template.Spec.Template.Spec.Containers[0].Env = envs
 
+	if job.Metadata.Labels == nil {
+		job.Metadata.Labels = make(map[string]string)
+	}
+	job.Metadata.Labels[managedByLabel] = managedByLabelValue
+
 	return job
 }
@@ -0,0 +1,264 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/karahiyo/actions-job/adapter"
+	"github.com/karahiyo/actions-job/config"
+	"google.golang.org/api/run/v1"
+)
+
+// fakeJobsAdapter keeps a job in memory, and rejects updates with stale resource versions like Cloud Run
+type fakeJobsAdapter struct {
+	adapter.JobsAdapter
+
+	job     *run.Job
+	version int
+	// modifiedOnce simulates a modification by another client before the next update
+	modifiedOnce bool
+	// createdConcurrently simulates a creation by another client before the create
+	createdConcurrently bool
+	// startErr is returned by the next start
+	startErr error
+	// overrides are the overrides of the last start
+	overrides *run.Overrides
+	creates   int
+	updates   int
+	starts    int
+	mu        sync.Mutex
+}
+
+func (f *fakeJobsAdapter) GetJob(_ context.Context, name string) (*run.Job, error) {
+	defer time.Sleep(time.Millisecond)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.job == nil {
+		return nil, fmt.Errorf("job dose not found: name=%s, %w", name, adapter.ErrJobNotFound)
+	}
+	return copyJob(f.job), nil
+}
+
+func (f *fakeJobsAdapter) CreateJob(_ context.Context, job *run.Job) (*run.Job, error) {
+	defer time.Sleep(time.Millisecond)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.creates++
+	if f.createdConcurrently {
+		f.createdConcurrently = false
+		f.store(job)
+	}
+	if f.job != nil {
+		return nil, &adapter.APIError{Err: errors.New("already exists"), Kind: adapter.ErrConflict, StatusCode: 409}
+	}
+	f.store(job)
+	return copyJob(f.job), nil
+}
+
+func (f *fakeJobsAdapter) UpdateJob(_ context.Context, _ string, job *run.Job) (*run.Job, error) {
+	defer time.Sleep(time.Millisecond)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.updates++
+	if f.modifiedOnce {
+		f.modifiedOnce = false
+		f.store(f.job)
+	}
+	if job.Metadata.ResourceVersion != strconv.Itoa(f.version) {
+		return nil, &adapter.APIError{Err: errors.New("resource version mismatch"), Kind: adapter.ErrConflict, StatusCode: 409}
+	}
+	f.store(job)
+	return copyJob(f.job), nil
+}
+
+func (f *fakeJobsAdapter) WaitJobReady(context.Context, string) (bool, error) {
+	return true, nil
+}
+
+// StartJob names the execution after the LABELS env of the current job spec
+func (f *fakeJobsAdapter) StartJob(_ context.Context, _ string, overrides *run.Overrides) (*run.Execution, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.starts++
+	f.overrides = overrides
+	if err := f.startErr; err != nil {
+		f.startErr = nil
+		return nil, err
+	}
+
+	var labels string
+	for _, env := range f.job.Spec.Template.Spec.Template.Spec.Containers[0].Env {
+		if env.Name == "LABELS" {
+			labels = env.Value
+		}
+	}
+	return &run.Execution{Metadata: &run.ObjectMeta{Name: labels}}, nil
+}
+
+func (f *fakeJobsAdapter) store(job *run.Job) {
+	f.version++
+	f.job = copyJob(job)
+	f.job.Metadata.ResourceVersion = strconv.Itoa(f.version)
+}
+
+func copyJob(job *run.Job) *run.Job {
+	b, _ := json.Marshal(job)
+	var copied run.Job
+	_ = json.Unmarshal(b, &copied)
+	return &copied
+}
+
+func loadTestConfig(t *testing.T) {
+	t.Helper()
+	t.Setenv("WEBHOOK_SECRET", "secret")
+	t.Setenv("GH_APP_PRIVATE_KEY", "key")
+	t.Setenv("GH_APP_ID", "1")
+	t.Setenv("GH_APP_INSTALLATION_ID", "2")
+	t.Setenv("RETRY_INITIAL_BACKOFF", "1ms")
+	t.Setenv("RETRY_MAX_BACKOFF", "5ms")
+	if _, err := config.Load(); err != nil {
+		t.Fatalf("failed to load config: %v", err)
+	}
+}
+
+func newTestJob(labels string) *run.Job {
+	job, err := parseJobManifest([]byte(`
+metadata:
+  name: test-job
+spec:
+  template:
+    spec:
+      template:
+        spec:
+          containers:
+            - image: runner
+`))
+	if err != nil {
+		panic(err)
+	}
+	return updateJobManifest(job, jobEnvs{owner: "org", repo: "repo", labels: []string{labels}})
+}
+
+func TestController_DispatchJobTransactionConcurrently(t *testing.T) {
+	loadTestConfig(t)
+	fake := &fakeJobsAdapter{}
+	c := &Controller{
+		jobLocks: newJobLocks(),
+		newJobsAdapter: func(context.Context, string, string) (adapter.JobsAdapter, error) {
+			return fake, nil
+		},
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		labels := fmt.Sprintf("dispatch-%d", i)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			execution, err := c.dispatchJobTransaction(context.Background(), "project", "region", "test-job", newTestJob(labels), nil)
+			if err != nil {
+				t.Errorf("dispatchJobTransaction() error = %v", err)
+				return
+			}
+			// the execution must start with the spec of its own dispatch, not overwritten by the others
+			if execution.Metadata.Name != labels {
+				t.Errorf("execution started with the spec of %s, want %s", execution.Metadata.Name, labels)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestController_DispatchJobTransactionRetriesConflict(t *testing.T) {
+	loadTestConfig(t)
+	fake := &fakeJobsAdapter{}
+	fake.store(newTestJob("old"))
+	fake.modifiedOnce = true
+	c := &Controller{
+		jobLocks: newJobLocks(),
+		newJobsAdapter: func(context.Context, string, string) (adapter.JobsAdapter, error) {
+			return fake, nil
+		},
+	}
+
+	execution, err := c.dispatchJobTransaction(context.Background(), "project", "region", "test-job", newTestJob("new"), nil)
+	if err != nil {
+		t.Fatalf("dispatchJobTransaction() error = %v", err)
+	}
+	if execution.Metadata.Name != "new" || fake.updates != 2 {
+		t.Errorf("execution = %s after %d updates, want the new spec after re-reading the modified job", execution.Metadata.Name, fake.updates)
+	}
+}
+
+func TestController_DispatchJobTransactionDoesNotRetryAmbiguousCalls(t *testing.T) {
+	loadTestConfig(t)
+	fake := &fakeJobsAdapter{
+		createdConcurrently: true,
+		startErr:            &adapter.APIError{Err: errors.New("internal error"), Kind: adapter.ErrUnavailable, StatusCode: 500},
+	}
+	c := &Controller{
+		jobLocks: newJobLocks(),
+		newJobsAdapter: func(context.Context, string, string) (adapter.JobsAdapter, error) {
+			return fake, nil
+		},
+	}
+
+	_, err := c.dispatchJobTransaction(context.Background(), "project", "region", "test-job", newTestJob("new"), nil)
+	if err == nil {
+		t.Fatal("dispatchJobTransaction() error = nil, want the start error")
+	}
+	// the conflicting create goes to the update, and the failed start may have started an execution
+	if fake.creates != 1 || fake.updates != 1 || fake.starts != 1 {
+		t.Errorf("creates = %d, updates = %d, starts = %d, want 1 each", fake.creates, fake.updates, fake.starts)
+	}
+}
+
+func TestController_DispatchJobTransactionOverrides(t *testing.T) {
+	loadTestConfig(t)
+	fake := &fakeJobsAdapter{}
+	c := &Controller{
+		jobLocks: newJobLocks(),
+		newJobsAdapter: func(context.Context, string, string) (adapter.JobsAdapter, error) {
+			return fake, nil
+		},
+	}
+
+	job := newTestJob("batch")
+	if _, err := c.dispatchJobTransaction(context.Background(), "project", "region", "test-job", job, executionOverrides(job, 3, "token")); err != nil {
+		t.Fatalf("dispatchJobTransaction() error = %v", err)
+	}
+	// the task count of the batch and the registration token are set on the execution, leaving the shared job spec as is
+	if fake.overrides == nil || fake.overrides.TaskCount != 3 {
+		t.Fatalf("overrides = %+v, want the task count 3", fake.overrides)
+	}
+	if envs := fake.overrides.ContainerOverrides[0].Env; len(envs) != 1 || envs[0].Name != "RUNNER_TOKEN" || envs[0].Value != "token" {
+		t.Errorf("container overrides envs = %+v, want RUNNER_TOKEN", envs)
+	}
+	if got := fake.job.Spec.Template.Spec.TaskCount; got != 0 {
+		t.Errorf("task count of the job spec = %d, want 0", got)
+	}
+	for _, env := range fake.job.Spec.Template.Spec.Template.Spec.Containers[0].Env {
+		if env.Name == "RUNNER_TOKEN" {
+			t.Errorf("RUNNER_TOKEN is written into the job spec")
+		}
+	}
+
+	if overrides := executionOverrides(job, 1, ""); overrides != nil {
+		t.Errorf("executionOverrides() = %+v, want nil for a single task without a token", overrides)
+	}
+}
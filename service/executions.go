@@ -0,0 +1,116 @@
+package service
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v52/github"
+)
+
+// maxExecutionLinks is the number of workflow jobs whose executions are remembered
+const maxExecutionLinks = 10000
+
+// ExecutionLink is the Cloud Run job execution started for a workflow job
+type ExecutionLink struct {
+	WorkflowJobID int64  `json:"workflow_job_id"`
+	Repository    string `json:"repository"`
+	Project       string `json:"project"`
+	Region        string `json:"region"`
+	Execution     string `json:"execution"`
+	// RunnerName is the runner that picked up the workflow job, known once it is in progress.
+	// It is the execution name, suffixed with the task index for batched workflow jobs.
+	RunnerName   string    `json:"runner_name,omitempty"`
+	DispatchedAt time.Time `json:"dispatched_at"`
+	ExecutionURL string    `json:"execution_url"`
+	LogsURL      string    `json:"logs_url"`
+}
+
+// executionLinks remembers the executions of the latest workflow jobs, evicting the oldest ones
+type executionLinks struct {
+	links map[int64]*ExecutionLink
+	order []int64
+	mu    sync.Mutex
+}
+
+func (l *executionLinks) record(event *github.WorkflowJobEvent, execution dispatchedExecution, now time.Time) ExecutionLink {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.links == nil {
+		l.links = make(map[int64]*ExecutionLink)
+	}
+
+	id := event.GetWorkflowJob().GetID()
+	if _, ok := l.links[id]; !ok {
+		l.order = append(l.order, id)
+	}
+	link := &ExecutionLink{
+		WorkflowJobID: id,
+		Repository:    event.GetRepo().GetFullName(),
+		Project:       execution.project,
+		Region:        execution.region,
+		Execution:     execution.name,
+		DispatchedAt:  now,
+		ExecutionURL:  executionURL(execution),
+		LogsURL:       executionLogsURL(execution, ""),
+	}
+	l.links[id] = link
+
+	for len(l.order) > maxExecutionLinks {
+		delete(l.links, l.order[0])
+		l.order = l.order[1:]
+	}
+
+	return *link
+}
+
+// started sets the runner that picked up the workflow job, and narrows the logs to its task
+func (l *executionLinks) started(jobID int64, runnerName string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	link, ok := l.links[jobID]
+	if !ok {
+		return
+	}
+	link.RunnerName = runnerName
+	if taskIndex, ok := strings.CutPrefix(runnerName, link.Execution+"-"); ok {
+		link.LogsURL = executionLogsURL(dispatchedExecution{project: link.Project, region: link.Region, name: link.Execution}, taskIndex)
+	}
+}
+
+func (l *executionLinks) get(jobID int64) (ExecutionLink, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	link, ok := l.links[jobID]
+	if !ok {
+		return ExecutionLink{}, false
+	}
+	return *link, true
+}
+
+// LookupExecution returns the execution started for the workflow job, if it was dispatched recently by this instance
+func (c *Controller) LookupExecution(workflowJobID int64) (ExecutionLink, bool) {
+	return c.executions.get(workflowJobID)
+}
+
+// executionURL is the Cloud Console page of the execution
+func executionURL(e dispatchedExecution) string {
+	return fmt.Sprintf("https://console.cloud.google.com/run/jobs/executions/details/%s/%s/tasks?project=%s",
+		e.region, e.name, url.QueryEscape(e.project))
+}
+
+// executionLogsURL is the Logs Explorer page of the execution, or of its task if taskIndex is not empty
+func executionLogsURL(e dispatchedExecution, taskIndex string) string {
+	query := fmt.Sprintf("resource.type=\"cloud_run_job\"\nlabels.\"run.googleapis.com/execution_name\"=%q", e.name)
+	if taskIndex != "" {
+		query += fmt.Sprintf("\nlabels.\"run.googleapis.com/task_index\"=%q", taskIndex)
+	}
+
+	return fmt.Sprintf("https://console.cloud.google.com/logs/query;query=%s?project=%s",
+		url.PathEscape(query), url.QueryEscape(e.project))
+}
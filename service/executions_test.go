@@ -0,0 +1,45 @@
+package service
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v52/github"
+)
+
+func TestExecutionLinks(t *testing.T) {
+	var l executionLinks
+	execution := dispatchedExecution{project: "my-project", region: "asia-northeast1", name: "runner-x7k2p"}
+	event := func(id int64) *github.WorkflowJobEvent {
+		return &github.WorkflowJobEvent{
+			Repo:        &github.Repository{FullName: github.String("owner/repo")},
+			WorkflowJob: &github.WorkflowJob{ID: github.Int64(id)},
+		}
+	}
+
+	link := l.record(event(1), execution, time.Now())
+	if link.ExecutionURL != "https://console.cloud.google.com/run/jobs/executions/details/asia-northeast1/runner-x7k2p/tasks?project=my-project" {
+		t.Errorf("ExecutionURL = %s", link.ExecutionURL)
+	}
+	if strings.Contains(link.LogsURL, "task_index") {
+		t.Errorf("LogsURL = %s, want the logs of the execution", link.LogsURL)
+	}
+
+	// the runner of a batched workflow job is suffixed with the task index
+	l.started(1, "runner-x7k2p-2")
+	got, ok := l.get(1)
+	if !ok || got.RunnerName != "runner-x7k2p-2" || !strings.Contains(got.LogsURL, "task_index%22=%222%22") {
+		t.Errorf("get() = %+v, %v, want the runner and the logs of its task", got, ok)
+	}
+
+	for id := int64(2); id <= maxExecutionLinks+1; id++ {
+		l.record(event(id), execution, time.Now())
+	}
+	if _, ok := l.get(1); ok {
+		t.Error("get() found the evicted workflow job")
+	}
+	if _, ok := l.get(maxExecutionLinks + 1); !ok {
+		t.Error("get() did not find the latest workflow job")
+	}
+}
@@ -0,0 +1,313 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v52/github"
+	"github.com/karahiyo/actions-job/adapter"
+	"github.com/karahiyo/actions-job/config"
+	"github.com/rs/zerolog"
+	"google.golang.org/api/run/v1"
+)
+
+const (
+	// managedByLabel is set to the jobs created by the controller, so that the unused ones are deleted by the garbage collection
+	managedByLabel      = "managed-by"
+	managedByLabelValue = "actions-job"
+
+	cloudRunJobLabel = "run.googleapis.com/job"
+)
+
+// GCReport lists what a garbage collection deleted, or would delete in dry-run mode
+type GCReport struct {
+	// Runners are "owner/repo/name" of the offline runners
+	Runners []string `json:"runners"`
+	// Jobs are "project/region/name" of the jobs not used in GCConfig.JobRetention
+	Jobs []string `json:"jobs"`
+	// Executions are "project/region/name" of the executions completed before GCConfig.ExecutionRetention
+	Executions []string `json:"executions"`
+	Errors     []string `json:"errors,omitempty"`
+	DryRun     bool     `json:"dryRun"`
+}
+
+func (r *GCReport) addError(err error) {
+	r.Errors = append(r.Errors, err.Error())
+}
+
+// RunGC periodically deletes offline runners, unused jobs and old executions, until ctx is done.
+// It does nothing if GCConfig.Interval is zero at startup.
+func (c *Controller) RunGC(ctx context.Context) {
+	if config.GetGCConfig().Interval == 0 {
+		return
+	}
+	logger := zerolog.Ctx(ctx)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(config.GetGCConfig().Interval):
+		}
+
+		report, err := c.CollectGarbage(ctx, config.GetGCConfig().DryRun)
+		if err != nil {
+			logger.Error().Err(err).Msg("failed to collect garbage")
+			continue
+		}
+
+		event := logger.Info()
+		if len(report.Errors) > 0 {
+			event = logger.Error().Strs("errors", report.Errors)
+		}
+		event.Bool("dry_run", report.DryRun).
+			Strs("runners", report.Runners).
+			Strs("jobs", report.Jobs).
+			Strs("executions", report.Executions).
+			Msgf("collected garbage: runners=%d, jobs=%d, executions=%d", len(report.Runners), len(report.Jobs), len(report.Executions))
+	}
+}
+
+// CollectGarbage deregisters the offline runners registered by executions, and deletes the jobs created by the controller
+// and not used in GCConfig.JobRetention, and their executions completed before GCConfig.ExecutionRetention.
+// Nothing is deleted if dryRun is true. Failures of each deletion are reported in GCReport.Errors.
+func (c *Controller) CollectGarbage(ctx context.Context, dryRun bool) (*GCReport, error) {
+	conf := config.GetGCConfig()
+
+	pattern, err := regexp.Compile(conf.RunnerNamePattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid runner name pattern: pattern=%s, %w", conf.RunnerNamePattern, err)
+	}
+
+	locations, err := gcLocations(ctx, conf)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &GCReport{DryRun: dryRun, Runners: []string{}, Jobs: []string{}, Executions: []string{}}
+
+	// the runners are listed before the executions, so that the executions of the listed runners are listed as well
+	runners, err := c.listOfflineRunners(ctx, pattern, report)
+	if err != nil {
+		report.addError(err)
+	}
+
+	now := time.Now()
+	executions := make(map[string]*run.Execution)
+	listed := true
+	for _, loc := range locations {
+		if err := c.collectJobs(ctx, loc[0], loc[1], conf, now, executions, report); err != nil {
+			report.addError(err)
+			listed = false
+		}
+	}
+
+	c.collectRunners(ctx, runners, executions, listed, conf.RunnerGracePeriod, now, report)
+
+	return report, nil
+}
+
+// offlineRunner is an offline runner registered by an execution
+type offlineRunner struct {
+	owner  string
+	repo   string
+	runner *github.Runner
+}
+
+// listOfflineRunners lists the offline runners with a job manifest label whose names match pattern
+func (c *Controller) listOfflineRunners(ctx context.Context, pattern *regexp.Regexp, report *GCReport) ([]offlineRunner, error) {
+	repos, err := c.gitHub().ListInstallationRepos(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var offline []offlineRunner
+	for _, repo := range repos {
+		if repo.GetArchived() {
+			continue
+		}
+
+		ownerRepo := strings.Split(repo.GetFullName(), "/")
+		runners, err := c.gitHub().ListRunners(ctx, ownerRepo[0], ownerRepo[1])
+		if err != nil {
+			report.addError(err)
+			continue
+		}
+
+		for _, r := range runners {
+			if r.GetStatus() != "offline" || !pattern.MatchString(r.GetName()) || !hasJobManifestLabel(r.Labels) {
+				continue
+			}
+			offline = append(offline, offlineRunner{owner: ownerRepo[0], repo: ownerRepo[1], runner: r})
+		}
+	}
+
+	return offline, nil
+}
+
+// collectRunners deregisters the offline runners, except the ones whose executions are running or created in gracePeriod.
+// The runners of executions not in executions are deregistered only if listed is true, i.e. the executions of all
+// locations were listed, since their executions were deleted.
+func (c *Controller) collectRunners(ctx context.Context, runners []offlineRunner, executions map[string]*run.Execution, listed bool, gracePeriod time.Duration, now time.Time, report *GCReport) {
+	for _, r := range runners {
+		if e, ok := runnerExecution(r.runner.GetName(), executions); ok {
+			if executionRunning(e) || now.Sub(executionCreatedAt(e)) < gracePeriod {
+				continue
+			}
+		} else if !listed {
+			continue
+		}
+
+		report.Runners = append(report.Runners, r.owner+"/"+r.repo+"/"+r.runner.GetName())
+		if report.DryRun {
+			continue
+		}
+		if err := c.gitHub().RemoveRunner(ctx, r.owner, r.repo, r.runner.GetID()); err != nil {
+			report.addError(err)
+		}
+	}
+}
+
+// runnerTaskIndexPattern matches the task index suffix of the runners of executions with multiple tasks
+var runnerTaskIndexPattern = regexp.MustCompile(`-[0-9]+$`)
+
+// runnerExecution returns the execution which registered the runner, named after the execution and suffixed with
+// the task index for executions with multiple tasks
+func runnerExecution(name string, executions map[string]*run.Execution) (*run.Execution, bool) {
+	if e, ok := executions[name]; ok {
+		return e, true
+	}
+	e, ok := executions[runnerTaskIndexPattern.ReplaceAllString(name, "")]
+	return e, ok
+}
+
+// executionRunning reports whether the execution has not completed
+func executionRunning(e *run.Execution) bool {
+	return e.Status == nil || e.Status.CompletionTime == ""
+}
+
+// executionCreatedAt returns when the execution was created, or now for broken timestamps so that its runners are kept
+func executionCreatedAt(e *run.Execution) time.Time {
+	t, err := time.Parse(time.RFC3339, e.Metadata.CreationTimestamp)
+	if err != nil {
+		return time.Now()
+	}
+	return t
+}
+
+// collectJobs deletes the unused jobs and the old executions of project and region. The listed executions are added to executions.
+func (c *Controller) collectJobs(ctx context.Context, project, region string, conf config.GCConfig, now time.Time, executions map[string]*run.Execution, report *GCReport) error {
+	jobsAdapter, err := c.newJobsAdapter(ctx, project, region)
+	if err != nil {
+		return fmt.Errorf("failed to initialize jobs client: %w", err)
+	}
+
+	jobs, err := jobsAdapter.ListJobs(ctx, managedByLabel+"="+managedByLabelValue)
+	if err != nil {
+		return err
+	}
+
+	// executions of the deleted jobs are deleted with them
+	managed := make(map[string]bool, len(jobs))
+	for _, job := range jobs {
+		name := job.Metadata.Name
+		managed[name] = true
+
+		lastUsed, running := jobLastUsed(job)
+		if running || now.Sub(lastUsed) < conf.JobRetention {
+			continue
+		}
+
+		managed[name] = false
+		report.Jobs = append(report.Jobs, project+"/"+region+"/"+name)
+		if report.DryRun {
+			continue
+		}
+		unlock := c.jobLocks.lock(project, region, name)
+		if err := jobsAdapter.DeleteJob(ctx, name); err != nil && !errors.Is(err, adapter.ErrJobNotFound) {
+			report.addError(err)
+		}
+		unlock()
+	}
+
+	listed, err := jobsAdapter.ListExecutions(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range listed {
+		executions[e.Metadata.Name] = e
+		if !managed[e.Metadata.Labels[cloudRunJobLabel]] || e.Status == nil {
+			continue
+		}
+
+		completedAt, err := time.Parse(time.RFC3339, e.Status.CompletionTime)
+		if err != nil || now.Sub(completedAt) < conf.ExecutionRetention {
+			// running executions have no completion time
+			continue
+		}
+
+		report.Executions = append(report.Executions, project+"/"+region+"/"+e.Metadata.Name)
+		if report.DryRun {
+			continue
+		}
+		if err := jobsAdapter.DeleteExecution(ctx, e.Metadata.Name); err != nil && !errors.Is(err, adapter.ErrJobNotFound) {
+			report.addError(err)
+		}
+	}
+
+	return nil
+}
+
+// jobLastUsed returns when the latest execution of the job was created, or when the job was created if it has no executions.
+// running is true if the latest execution has not completed.
+func jobLastUsed(job *run.Job) (lastUsed time.Time, running bool) {
+	created := job.Metadata.CreationTimestamp
+	if job.Status != nil && job.Status.LatestCreatedExecution != nil {
+		latest := job.Status.LatestCreatedExecution
+		if latest.CompletionTimestamp == "" {
+			return time.Time{}, true
+		}
+		created = latest.CreationTimestamp
+	}
+
+	// jobs with broken timestamps are kept
+	t, err := time.Parse(time.RFC3339, created)
+	if err != nil {
+		return time.Time{}, true
+	}
+
+	return t, false
+}
+
+func hasJobManifestLabel(labels []*github.RunnerLabels) bool {
+	for _, l := range labels {
+		if strings.HasPrefix(l.GetName(), "job-manifest=") {
+			return true
+		}
+	}
+	return false
+}
+
+// gcLocations returns the pairs of project and region to collect jobs and executions from
+func gcLocations(ctx context.Context, conf config.GCConfig) ([][2]string, error) {
+	if len(conf.Locations) == 0 {
+		meta, err := adapter.GetInstanceMetadata(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get instance metadata: %w", err)
+		}
+		return [][2]string{{meta.ProjectID, meta.Region}}, nil
+	}
+
+	locations := make([][2]string, 0, len(conf.Locations))
+	for _, loc := range conf.Locations {
+		project, region, _ := strings.Cut(loc, "/")
+		locations = append(locations, [2]string{project, region})
+	}
+
+	return locations, nil
+}
@@ -0,0 +1,223 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v52/github"
+	"github.com/karahiyo/actions-job/adapter"
+	"google.golang.org/api/run/v1"
+)
+
+// fakeGCGitHubAdapter lists the runners of a repository, and records the removed ones
+type fakeGCGitHubAdapter struct {
+	adapter.GitHubAdapter
+
+	runners []*github.Runner
+	removed []int64
+}
+
+func (f *fakeGCGitHubAdapter) ListInstallationRepos(context.Context) ([]*github.Repository, error) {
+	return []*github.Repository{{FullName: github.String("owner/repo")}}, nil
+}
+
+func (f *fakeGCGitHubAdapter) ListRunners(context.Context, string, string) ([]*github.Runner, error) {
+	return f.runners, nil
+}
+
+func (f *fakeGCGitHubAdapter) RemoveRunner(_ context.Context, _, _ string, runnerID int64) error {
+	f.removed = append(f.removed, runnerID)
+	return nil
+}
+
+// fakeGCJobsAdapter lists jobs and executions, and records the deleted ones
+type fakeGCJobsAdapter struct {
+	adapter.JobsAdapter
+
+	jobs              []*run.Job
+	executions        []*run.Execution
+	listErr           error
+	deletedJobs       []string
+	deletedExecutions []string
+}
+
+func (f *fakeGCJobsAdapter) ListJobs(context.Context, string) ([]*run.Job, error) {
+	return f.jobs, nil
+}
+
+func (f *fakeGCJobsAdapter) ListExecutions(context.Context) ([]*run.Execution, error) {
+	return f.executions, f.listErr
+}
+
+func (f *fakeGCJobsAdapter) DeleteJob(_ context.Context, name string) error {
+	f.deletedJobs = append(f.deletedJobs, name)
+	return nil
+}
+
+func (f *fakeGCJobsAdapter) DeleteExecution(_ context.Context, name string) error {
+	f.deletedExecutions = append(f.deletedExecutions, name)
+	return nil
+}
+
+func TestController_CollectGarbage(t *testing.T) {
+	t.Setenv("GC_LOCATIONS", "project/region")
+	loadTestConfig(t)
+	now := time.Now()
+	ago := func(d time.Duration) string {
+		return now.Add(-d).Format(time.RFC3339)
+	}
+	newRunner := func(id int64, name, status string) *github.Runner {
+		return &github.Runner{
+			ID:     github.Int64(id),
+			Name:   github.String(name),
+			Status: github.String(status),
+			Labels: []*github.RunnerLabels{{Name: github.String("job-manifest=job.yaml")}},
+		}
+	}
+	newExecution := func(name, job string, created time.Duration, completed string) *run.Execution {
+		return &run.Execution{
+			Metadata: &run.ObjectMeta{Name: name, CreationTimestamp: ago(created), Labels: map[string]string{cloudRunJobLabel: job}},
+			Status:   &run.ExecutionStatus{CompletionTime: completed},
+		}
+	}
+
+	tests := []struct {
+		name           string
+		dryRun         bool
+		listErr        error
+		wantRunners    []string
+		wantJobs       []string
+		wantExecutions []string
+	}{
+		{
+			name:           "collect",
+			wantRunners:    []string{"owner/repo/job-aaaaa", "owner/repo/job-ddddd"},
+			wantJobs:       []string{"project/region/old-job"},
+			wantExecutions: []string{"project/region/job-aaaaa"},
+		},
+		{
+			name:           "dry run",
+			dryRun:         true,
+			wantRunners:    []string{"owner/repo/job-aaaaa", "owner/repo/job-ddddd"},
+			wantJobs:       []string{"project/region/old-job"},
+			wantExecutions: []string{"project/region/job-aaaaa"},
+		},
+		{
+			// the runners of the executions not listed may be registered by the executions of the failed location
+			name:        "executions not listed",
+			listErr:     errors.New("unavailable"),
+			wantRunners: []string{},
+			wantJobs:    []string{"project/region/old-job"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gh := &fakeGCGitHubAdapter{runners: []*github.Runner{
+				// completed long ago
+				newRunner(1, "job-aaaaa", "offline"),
+				// running
+				newRunner(2, "job-bbbbb-1", "offline"),
+				// completed, but created in the grace period
+				newRunner(3, "job-ccccc", "offline"),
+				// the execution was deleted
+				newRunner(4, "job-ddddd", "offline"),
+				newRunner(5, "job-eeeee", "online"),
+				newRunner(6, "not-an-execution", "offline"),
+			}}
+			jobs := &fakeGCJobsAdapter{
+				jobs: []*run.Job{
+					{
+						Metadata: &run.ObjectMeta{Name: "job", CreationTimestamp: ago(1000 * time.Hour)},
+						Status:   &run.JobStatus{LatestCreatedExecution: &run.ExecutionReference{CreationTimestamp: ago(time.Minute)}},
+					},
+					{
+						Metadata: &run.ObjectMeta{Name: "old-job", CreationTimestamp: ago(1000 * time.Hour)},
+						Status:   &run.JobStatus{LatestCreatedExecution: &run.ExecutionReference{CreationTimestamp: ago(200 * time.Hour), CompletionTimestamp: ago(200 * time.Hour)}},
+					},
+				},
+				executions: []*run.Execution{
+					newExecution("job-aaaaa", "job", 48*time.Hour, ago(47*time.Hour)),
+					newExecution("job-bbbbb", "job", time.Hour, ""),
+					newExecution("job-ccccc", "job", time.Minute, ago(time.Minute)),
+					newExecution("unmanaged-fffff", "unmanaged", 48*time.Hour, ago(47*time.Hour)),
+				},
+				listErr: tt.listErr,
+			}
+			c := &Controller{
+				ghAdapter: gh,
+				jobLocks:  newJobLocks(),
+				newJobsAdapter: func(context.Context, string, string) (adapter.JobsAdapter, error) {
+					return jobs, nil
+				},
+			}
+
+			report, err := c.CollectGarbage(context.Background(), tt.dryRun)
+			if err != nil {
+				t.Fatalf("CollectGarbage() error = %v", err)
+			}
+			if tt.wantExecutions == nil {
+				tt.wantExecutions = []string{}
+			}
+			sort.Strings(report.Runners)
+			if !reflect.DeepEqual(report.Runners, tt.wantRunners) ||
+				!reflect.DeepEqual(report.Jobs, tt.wantJobs) ||
+				!reflect.DeepEqual(report.Executions, tt.wantExecutions) {
+				t.Errorf("CollectGarbage() = runners %v, jobs %v, executions %v, want %v, %v, %v",
+					report.Runners, report.Jobs, report.Executions, tt.wantRunners, tt.wantJobs, tt.wantExecutions)
+			}
+
+			removed := len(gh.removed) + len(jobs.deletedJobs) + len(jobs.deletedExecutions)
+			if tt.dryRun && removed != 0 {
+				t.Errorf("deleted %d runners, jobs and executions in dry-run mode", removed)
+			}
+			if !tt.dryRun && removed != len(tt.wantRunners)+len(tt.wantJobs)+len(tt.wantExecutions) {
+				t.Errorf("deleted %d runners, jobs and executions, want the reported ones", removed)
+			}
+		})
+	}
+}
+
+func TestJobLastUsed(t *testing.T) {
+	created := "2023-06-01T00:00:00Z"
+	executed := "2023-06-10T00:00:00Z"
+
+	tests := []struct {
+		name        string
+		status      *run.JobStatus
+		wantUsed    string
+		wantRunning bool
+	}{
+		{name: "never executed", status: nil, wantUsed: created},
+		{
+			name:     "latest execution completed",
+			status:   &run.JobStatus{LatestCreatedExecution: &run.ExecutionReference{CreationTimestamp: executed, CompletionTimestamp: executed}},
+			wantUsed: executed,
+		},
+		{
+			name:        "latest execution running",
+			status:      &run.JobStatus{LatestCreatedExecution: &run.ExecutionReference{CreationTimestamp: executed}},
+			wantRunning: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			job := &run.Job{Metadata: &run.ObjectMeta{CreationTimestamp: created}, Status: tt.status}
+
+			used, running := jobLastUsed(job)
+			if running != tt.wantRunning {
+				t.Fatalf("jobLastUsed() running = %v, want %v", running, tt.wantRunning)
+			}
+			if tt.wantRunning {
+				return
+			}
+			if want, _ := time.Parse(time.RFC3339, tt.wantUsed); !used.Equal(want) {
+				t.Errorf("jobLastUsed() = %s, want %s", used, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package service
+
+import (
+	"fmt"
+
+	"github.com/karahiyo/actions-job/adapter"
+	"github.com/karahiyo/actions-job/config"
+)
+
+// gitHub returns the GitHub adapter built with the current GitHub App config
+func (c *Controller) gitHub() adapter.GitHubAdapter {
+	c.ghMu.RLock()
+	defer c.ghMu.RUnlock()
+
+	return c.ghAdapter
+}
+
+// gitHubFor returns the GitHub adapter with the credentials of the webhook source. If source is empty,
+// it returns the adapter of installationID of the GitHub App, or of GitHubAppConfig.InstallationID if installationID is zero.
+// Sources and installations sharing the installation of GitHubAppConfig share its adapter.
+func (c *Controller) gitHubFor(source string, installationID int64) (adapter.GitHubAdapter, error) {
+	var sourceConf config.SourceConfig
+	if source != "" {
+		var ok bool
+		if sourceConf, ok = config.GetSourceConfig(source); !ok {
+			return nil, fmt.Errorf("webhook source is not configured: source=%s, %w", source, ErrPermanent)
+		}
+	}
+
+	c.ghMu.Lock()
+	defer c.ghMu.Unlock()
+
+	conf, key := c.ghConfig, source
+	switch {
+	case source != "":
+		conf = sourceConf.GitHubAppConfig(c.ghConfig)
+	case installationID != 0:
+		// source names do not contain "/"
+		conf.InstallationID, key = installationID, fmt.Sprintf("installation/%d", installationID)
+	}
+	if conf == c.ghConfig {
+		return c.ghAdapter, nil
+	}
+	if cached, ok := c.sourceAdapters[key]; ok && cached.conf == conf {
+		return cached.adapter, nil
+	}
+
+	ghAdapter, err := c.newGitHubAdapter(conf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize github client: source=%s, installation_id=%d, %w", source, conf.InstallationID, err)
+	}
+	if c.sourceAdapters == nil {
+		c.sourceAdapters = make(map[string]sourceAdapter)
+	}
+	c.sourceAdapters[key] = sourceAdapter{conf: conf, adapter: ghAdapter}
+
+	return ghAdapter, nil
+}
+
+// sourceAdapter is the GitHub adapter of a webhook source or an installation, rebuilt when conf changes
+type sourceAdapter struct {
+	conf    config.GitHubAppConfig
+	adapter adapter.GitHubAdapter
+}
+
+// reloadGitHubAdapter rebuilds the GitHub adapter if conf changed, e.g. the private key was rotated
+func (c *Controller) reloadGitHubAdapter(conf config.GitHubAppConfig) error {
+	c.ghMu.Lock()
+	defer c.ghMu.Unlock()
+
+	if conf == c.ghConfig {
+		return nil
+	}
+
+	ghAdapter, err := c.newGitHubAdapter(conf)
+	if err != nil {
+		return fmt.Errorf("failed to initialize github client: %w", err)
+	}
+	c.ghAdapter = ghAdapter
+	c.ghConfig = conf
+
+	return nil
+}
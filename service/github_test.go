@@ -0,0 +1,71 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/karahiyo/actions-job/adapter"
+	"github.com/karahiyo/actions-job/config"
+)
+
+// fakeGitHubAdapter is a GitHub adapter built for a GitHub App config
+type fakeGitHubAdapter struct {
+	adapter.GitHubAdapter
+
+	conf config.GitHubAppConfig
+}
+
+func TestController_gitHubFor(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(`
+sources:
+  - name: same-installation
+    repositories: [org/repo]
+    webhookSecret: secret-1
+  - name: other-org
+    repositories: [other-org/*]
+    webhookSecret: secret-2
+    installationID: 3
+`), 0o600); err != nil {
+		t.Fatalf("failed to write config file: %v", err)
+	}
+	t.Setenv("CONFIG_FILE", path)
+	loadTestConfig(t)
+
+	built := 0
+	c := &Controller{
+		ghConfig: config.GetGitHubAppConfig(),
+		newGitHubAdapter: func(conf config.GitHubAppConfig) (adapter.GitHubAdapter, error) {
+			built++
+			return &fakeGitHubAdapter{conf: conf}, nil
+		},
+	}
+	c.ghAdapter, _ = c.newGitHubAdapter(c.ghConfig)
+
+	if gh, err := c.gitHubFor("same-installation", 0); err != nil || gh != c.ghAdapter {
+		t.Errorf("gitHubFor() = %v, %v, want the adapter of the GitHub App", gh, err)
+	}
+
+	gh, err := c.gitHubFor("other-org", 0)
+	if err != nil {
+		t.Fatalf("gitHubFor() error = %v", err)
+	}
+	if conf := gh.(*fakeGitHubAdapter).conf; conf.InstallationID != 3 || conf.AppID != 1 {
+		t.Errorf("gitHubFor() built with %+v, want the installation 3 of the GitHub App", conf)
+	}
+	if again, _ := c.gitHubFor("other-org", 0); again != gh || built != 2 {
+		t.Errorf("gitHubFor() built %d adapters, want the adapter of the source to be reused", built)
+	}
+
+	if gh, _ := c.gitHubFor("", 2); gh != c.ghAdapter {
+		t.Error("gitHubFor() did not return the adapter of the GitHub App for its installation")
+	}
+	if gh, _ := c.gitHubFor("", 4); gh.(*fakeGitHubAdapter).conf.InstallationID != 4 {
+		t.Error("gitHubFor() did not return the adapter of the installation of the event")
+	}
+
+	if _, err := c.gitHubFor("unknown", 0); err == nil {
+		t.Error("gitHubFor() error = nil for an unknown source")
+	}
+}
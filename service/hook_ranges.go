@@ -0,0 +1,117 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/karahiyo/actions-job/config"
+	"github.com/rs/zerolog"
+)
+
+// fallbackHookRanges are the hooks IP ranges of GitHub.com, used until the ranges are fetched from the meta API
+var fallbackHookRanges = []string{
+	"192.30.252.0/22",
+	"185.199.108.0/22",
+	"140.82.112.0/20",
+	"143.55.64.0/20",
+	"2a0a:a440::/29",
+	"2606:50c0::/32",
+}
+
+// hookRanges caches the IP ranges that GitHub sends webhooks from
+type hookRanges struct {
+	prefixes []netip.Prefix
+	mu       sync.RWMutex
+}
+
+// set replaces the cached ranges. Nothing is replaced if any of ranges is invalid.
+func (h *hookRanges) set(ranges []string) error {
+	if len(ranges) == 0 {
+		return fmt.Errorf("no hooks ip ranges")
+	}
+
+	prefixes := make([]netip.Prefix, 0, len(ranges))
+	for _, r := range ranges {
+		p, err := netip.ParsePrefix(r)
+		if err != nil {
+			return fmt.Errorf("failed to parse hooks ip range: range=%s, %w", r, err)
+		}
+		prefixes = append(prefixes, p)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.prefixes = prefixes
+
+	return nil
+}
+
+// contains reports whether addr is in the cached ranges, or in fallbackHookRanges if they are not fetched yet and fallback is set
+func (h *hookRanges) contains(addr netip.Addr, fallback bool) bool {
+	h.mu.RLock()
+	prefixes := h.prefixes
+	h.mu.RUnlock()
+
+	if prefixes == nil && fallback {
+		for _, r := range fallbackHookRanges {
+			prefixes = append(prefixes, netip.MustParsePrefix(r))
+		}
+	}
+
+	addr = addr.Unmap()
+	for _, p := range prefixes {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// AllowsWebhookIP reports whether addr is in ServerConfig.WebhookIPRanges or in the hooks IP ranges of GitHub.
+// The ranges of GitHub.com are not used for GitHub Enterprise Server until the ranges are fetched.
+func (c *Controller) AllowsWebhookIP(addr netip.Addr) bool {
+	for _, r := range config.GetServerConfig().WebhookIPRanges {
+		// validated by the config
+		if p, err := netip.ParsePrefix(r); err == nil && p.Contains(addr.Unmap()) {
+			return true
+		}
+	}
+
+	return c.hookRanges.contains(addr, config.GetGitHubAppConfig().APIURL == "")
+}
+
+// RunHookRanges fetches the hooks IP ranges from the GitHub meta API every ServerConfig.WebhookIPRangesRefreshInterval,
+// until ctx is done. Fetching is skipped while ServerConfig.WebhookIPAllowlist is not set, so that enabling it by
+// reloading the config takes effect. The last fetched ranges are kept when fetching fails.
+func (c *Controller) RunHookRanges(ctx context.Context) {
+	for {
+		if config.GetServerConfig().WebhookIPAllowlist {
+			if err := c.refreshHookRanges(ctx); err != nil {
+				zerolog.Ctx(ctx).Error().Err(err).Msg("failed to refresh hooks ip ranges, keeping the current ones")
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(config.GetServerConfig().WebhookIPRangesRefreshInterval):
+		}
+	}
+}
+
+func (c *Controller) refreshHookRanges(ctx context.Context) error {
+	ranges, err := c.gitHub().GetHookRanges(ctx)
+	if err != nil {
+		return err
+	}
+	if err := c.hookRanges.set(ranges); err != nil {
+		return err
+	}
+	zerolog.Ctx(ctx).Debug().Msgf("refreshed %d hooks ip ranges", len(ranges))
+
+	return nil
+}
@@ -0,0 +1,51 @@
+package service
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestHookRanges(t *testing.T) {
+	var h hookRanges
+
+	// the fallback ranges are used until fetched
+	if !h.contains(netip.MustParseAddr("140.82.115.1"), true) || h.contains(netip.MustParseAddr("203.0.113.1"), true) {
+		t.Error("contains() does not match the fallback ranges")
+	}
+	if h.contains(netip.MustParseAddr("140.82.115.1"), false) {
+		t.Error("contains() matches the fallback ranges without fallback")
+	}
+
+	if err := h.set([]string{"203.0.113.0/24", "2001:db8::/32"}); err != nil {
+		t.Fatalf("set() error = %v", err)
+	}
+	if !h.contains(netip.MustParseAddr("::ffff:203.0.113.1"), true) || !h.contains(netip.MustParseAddr("2001:db8::1"), true) {
+		t.Error("contains() does not match the fetched ranges")
+	}
+	if h.contains(netip.MustParseAddr("140.82.115.1"), true) {
+		t.Error("contains() matches the fallback ranges after fetched")
+	}
+
+	// invalid ranges do not replace the cached ones
+	if err := h.set([]string{"203.0.113.0/24", "invalid"}); err == nil {
+		t.Error("set() error = nil for an invalid range")
+	}
+	if !h.contains(netip.MustParseAddr("2001:db8::1"), true) {
+		t.Error("contains() does not match the ranges kept after an invalid update")
+	}
+}
+
+func TestController_AllowsWebhookIP(t *testing.T) {
+	t.Setenv("WEBHOOK_IP_RANGES", "10.0.0.0/8")
+	t.Setenv("GH_API_URL", "https://ghes.example.com/api/v3")
+	loadTestConfig(t)
+	c := &Controller{}
+
+	if !c.AllowsWebhookIP(netip.MustParseAddr("10.1.2.3")) {
+		t.Error("AllowsWebhookIP() = false for the configured range")
+	}
+	// GitHub Enterprise Server does not send webhooks from GitHub.com
+	if c.AllowsWebhookIP(netip.MustParseAddr("140.82.115.1")) {
+		t.Error("AllowsWebhookIP() = true for the fallback ranges of GitHub.com")
+	}
+}
@@ -0,0 +1,202 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v52/github"
+	"github.com/karahiyo/actions-job/config"
+	"github.com/rs/zerolog"
+)
+
+// Installation is an installation of the GitHub App known from the GitHub API and the installation and installation_repositories events
+type Installation struct {
+	ID        int64  `json:"id"`
+	Account   string `json:"account"`
+	Suspended bool   `json:"suspended"`
+	// RepositorySelection is "all" or "selected". Repositories are only known for the selected ones.
+	RepositorySelection string    `json:"repository_selection"`
+	Repositories        []string  `json:"repositories,omitempty"`
+	UpdatedAt           time.Time `json:"updated_at"`
+}
+
+// installationRegistry keeps the installations of the GitHub App and their repositories up to date with the webhook events.
+// It is seeded and refreshed with the installations listed by the GitHub API, and the events are applied on top of them.
+type installationRegistry struct {
+	installations map[int64]*installationState
+	mu            sync.Mutex
+}
+
+type installationState struct {
+	Installation
+	repos map[string]struct{}
+}
+
+// get returns the state of the installation, adding it if unknown. It must be called with the lock held.
+func (r *installationRegistry) get(inst *github.Installation, now time.Time) *installationState {
+	if r.installations == nil {
+		r.installations = make(map[int64]*installationState)
+	}
+
+	s, ok := r.installations[inst.GetID()]
+	if !ok {
+		s = &installationState{Installation: Installation{ID: inst.GetID()}, repos: make(map[string]struct{})}
+		r.installations[inst.GetID()] = s
+	}
+	s.Account = inst.GetAccount().GetLogin()
+	if inst.RepositorySelection != nil {
+		s.RepositorySelection = inst.GetRepositorySelection()
+	}
+	s.UpdatedAt = now
+
+	return s
+}
+
+func (r *installationRegistry) applyInstallation(event *github.InstallationEvent, now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	switch event.GetAction() {
+	case "deleted":
+		delete(r.installations, event.GetInstallation().GetID())
+		return
+	case "created":
+		s := r.get(event.GetInstallation(), now)
+		for _, repo := range event.Repositories {
+			s.repos[repo.GetFullName()] = struct{}{}
+		}
+	case "suspend":
+		r.get(event.GetInstallation(), now).Suspended = true
+	case "unsuspend":
+		r.get(event.GetInstallation(), now).Suspended = false
+	default:
+		r.get(event.GetInstallation(), now)
+	}
+}
+
+func (r *installationRegistry) applyRepositories(event *github.InstallationRepositoriesEvent, now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s := r.get(event.GetInstallation(), now)
+	if event.RepositorySelection != nil {
+		s.RepositorySelection = event.GetRepositorySelection()
+	}
+	for _, repo := range event.RepositoriesAdded {
+		s.repos[repo.GetFullName()] = struct{}{}
+	}
+	for _, repo := range event.RepositoriesRemoved {
+		delete(s.repos, repo.GetFullName())
+	}
+}
+
+// seed replaces the installations with the ones listed by the GitHub API, keeping the repositories known from the events.
+// The repositories of the listed installations are not known until their events are received.
+func (r *installationRegistry) seed(installations []*github.Installation, now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	listed := make(map[int64]struct{}, len(installations))
+	for _, inst := range installations {
+		listed[inst.GetID()] = struct{}{}
+		r.get(inst, now).Suspended = inst.SuspendedAt != nil
+	}
+	for id := range r.installations {
+		if _, ok := listed[id]; !ok {
+			delete(r.installations, id)
+		}
+	}
+}
+
+// suspended reports whether the installation is known to be suspended
+func (r *installationRegistry) suspended(id int64) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s, ok := r.installations[id]
+	return ok && s.Suspended
+}
+
+// list returns the known installations ordered by ID
+func (r *installationRegistry) list() []Installation {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	installations := make([]Installation, 0, len(r.installations))
+	for _, s := range r.installations {
+		inst := s.Installation
+		inst.Repositories = make([]string, 0, len(s.repos))
+		for repo := range s.repos {
+			inst.Repositories = append(inst.Repositories, repo)
+		}
+		sort.Strings(inst.Repositories)
+		installations = append(installations, inst)
+	}
+	sort.Slice(installations, func(i, j int) bool { return installations[i].ID < installations[j].ID })
+
+	return installations
+}
+
+// ReceiveInstallationEvent updates the installation registry with an installation event
+func (c *Controller) ReceiveInstallationEvent(ctx context.Context, event *github.InstallationEvent) error {
+	if event.GetInstallation().GetID() == 0 {
+		return fmt.Errorf("installation is not found in the event: %w", ErrBadRequest)
+	}
+
+	c.installations.applyInstallation(event, time.Now())
+	zerolog.Ctx(ctx).Info().
+		Int64("installation_id", event.GetInstallation().GetID()).
+		Str("account", event.GetInstallation().GetAccount().GetLogin()).
+		Msgf("installation %s", event.GetAction())
+
+	return nil
+}
+
+// ReceiveInstallationRepositoriesEvent updates the repositories of the installation in the registry
+func (c *Controller) ReceiveInstallationRepositoriesEvent(ctx context.Context, event *github.InstallationRepositoriesEvent) error {
+	if event.GetInstallation().GetID() == 0 {
+		return fmt.Errorf("installation is not found in the event: %w", ErrBadRequest)
+	}
+
+	c.installations.applyRepositories(event, time.Now())
+	zerolog.Ctx(ctx).Info().
+		Int64("installation_id", event.GetInstallation().GetID()).
+		Msgf("%d repositories added to and %d removed from the installation", len(event.RepositoriesAdded), len(event.RepositoriesRemoved))
+
+	return nil
+}
+
+// RunInstallations seeds the installation registry with the installations of the GitHub App at startup, and refreshes it
+// every ServerConfig.InstallationsRefreshInterval until ctx is done. The registry is only filled by the events if listing fails.
+func (c *Controller) RunInstallations(ctx context.Context) {
+	for {
+		if err := c.refreshInstallations(ctx); err != nil {
+			zerolog.Ctx(ctx).Error().Err(err).Msg("failed to refresh installations, keeping the current ones")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(config.GetServerConfig().InstallationsRefreshInterval):
+		}
+	}
+}
+
+func (c *Controller) refreshInstallations(ctx context.Context) error {
+	installations, err := c.gitHub().ListAppInstallations(ctx)
+	if err != nil {
+		return err
+	}
+	c.installations.seed(installations, time.Now())
+	zerolog.Ctx(ctx).Debug().Msgf("refreshed %d installations", len(installations))
+
+	return nil
+}
+
+// Installations returns the installations of the GitHub App known from the GitHub API and the webhook events
+func (c *Controller) Installations() []Installation {
+	return c.installations.list()
+}
@@ -0,0 +1,122 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v52/github"
+	"github.com/karahiyo/actions-job/adapter"
+)
+
+func TestController_ReceiveInstallationEvents(t *testing.T) {
+	loadTestConfig(t)
+	ctx := context.Background()
+	c := &Controller{}
+	installation := &github.Installation{
+		ID:                  github.Int64(3),
+		Account:             &github.User{Login: github.String("other-org")},
+		RepositorySelection: github.String("selected"),
+	}
+	repo := func(name string) *github.Repository {
+		return &github.Repository{FullName: github.String(name)}
+	}
+
+	events := []any{
+		&github.InstallationEvent{Action: github.String("created"), Installation: installation, Repositories: []*github.Repository{repo("other-org/a"), repo("other-org/b")}},
+		&github.InstallationRepositoriesEvent{
+			Action:              github.String("added"),
+			Installation:        installation,
+			RepositoriesAdded:   []*github.Repository{repo("other-org/c")},
+			RepositoriesRemoved: []*github.Repository{repo("other-org/a")},
+		},
+		&github.InstallationEvent{Action: github.String("suspend"), Installation: installation},
+	}
+	for _, e := range events {
+		var err error
+		switch e := e.(type) {
+		case *github.InstallationEvent:
+			err = c.ReceiveInstallationEvent(ctx, e)
+		case *github.InstallationRepositoriesEvent:
+			err = c.ReceiveInstallationRepositoriesEvent(ctx, e)
+		}
+		if err != nil {
+			t.Fatalf("failed to receive %T: %v", e, err)
+		}
+	}
+
+	got := c.Installations()
+	if len(got) != 1 || got[0].Account != "other-org" || !got[0].Suspended ||
+		!reflect.DeepEqual(got[0].Repositories, []string{"other-org/b", "other-org/c"}) {
+		t.Errorf("Installations() = %+v, want the suspended installation with the current repositories", got)
+	}
+	if ids := c.reconciledInstallations(); !reflect.DeepEqual(ids, []int64{2}) {
+		t.Errorf("reconciledInstallations() = %v, want only the installation of the config", ids)
+	}
+
+	// workflow jobs of the suspended installation are not dispatched
+	event := newTestEvent(1)
+	event.Repo.Private = github.Bool(true)
+	event.Installation = installation
+	if err := c.ReceiveWorkflowJobEvent(ctx, "", event); !errors.Is(err, ErrNonTargetEvent) {
+		t.Errorf("ReceiveWorkflowJobEvent() error = %v, want ErrNonTargetEvent", err)
+	}
+
+	if err := c.ReceiveInstallationEvent(ctx, &github.InstallationEvent{Action: github.String("deleted"), Installation: installation}); err != nil {
+		t.Fatalf("ReceiveInstallationEvent() error = %v", err)
+	}
+	if got := c.Installations(); len(got) != 0 {
+		t.Errorf("Installations() = %+v, want the deleted installation to be removed", got)
+	}
+}
+
+// fakeInstallationsAdapter lists the installations of the GitHub App
+type fakeInstallationsAdapter struct {
+	adapter.GitHubAdapter
+
+	installations []*github.Installation
+}
+
+func (f *fakeInstallationsAdapter) ListAppInstallations(context.Context) ([]*github.Installation, error) {
+	return f.installations, nil
+}
+
+func TestController_RefreshInstallations(t *testing.T) {
+	ctx := context.Background()
+	account := &github.User{Login: github.String("other-org")}
+	fake := &fakeInstallationsAdapter{}
+	c := &Controller{ghAdapter: fake}
+
+	// known from the events before the refresh
+	for _, id := range []int64{3, 5} {
+		event := &github.InstallationEvent{
+			Action:       github.String("created"),
+			Installation: &github.Installation{ID: github.Int64(id), Account: account},
+			Repositories: []*github.Repository{{FullName: github.String("other-org/a")}},
+		}
+		if err := c.ReceiveInstallationEvent(ctx, event); err != nil {
+			t.Fatalf("ReceiveInstallationEvent() error = %v", err)
+		}
+	}
+
+	fake.installations = []*github.Installation{
+		{ID: github.Int64(3), Account: account, SuspendedAt: &github.Timestamp{Time: time.Now()}},
+		{ID: github.Int64(4), Account: &github.User{Login: github.String("new-org")}, RepositorySelection: github.String("all")},
+	}
+	if err := c.refreshInstallations(ctx); err != nil {
+		t.Fatalf("refreshInstallations() error = %v", err)
+	}
+
+	got := c.Installations()
+	if len(got) != 2 || got[0].ID != 3 || got[1].ID != 4 {
+		t.Fatalf("Installations() = %+v, want the listed installations only", got)
+	}
+	if !got[0].Suspended || !reflect.DeepEqual(got[0].Repositories, []string{"other-org/a"}) {
+		t.Errorf("Installations()[0] = %+v, want the suspended installation keeping the repositories of the events", got[0])
+	}
+	if got[1].Account != "new-org" || got[1].RepositorySelection != "all" {
+		t.Errorf("Installations()[1] = %+v, want the installation not known from the events", got[1])
+	}
+}
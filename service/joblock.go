@@ -0,0 +1,46 @@
+package service
+
+import "sync"
+
+// jobLocks serializes the operations on the same job, so that concurrent dispatches do not overwrite each other's job spec
+// between updating the job and starting its execution
+type jobLocks struct {
+	locks map[string]*jobLock
+	mu    sync.Mutex
+}
+
+type jobLock struct {
+	mu sync.Mutex
+	// waiters is the number of holders and waiters, the lock is deleted when it becomes zero
+	waiters int
+}
+
+func newJobLocks() *jobLocks {
+	return &jobLocks{locks: make(map[string]*jobLock)}
+}
+
+// lock locks the job identified by project, region and name, and returns the function to unlock it
+func (l *jobLocks) lock(project, region, name string) func() {
+	key := project + "/" + region + "/" + name
+
+	l.mu.Lock()
+	jl, ok := l.locks[key]
+	if !ok {
+		jl = &jobLock{}
+		l.locks[key] = jl
+	}
+	jl.waiters++
+	l.mu.Unlock()
+
+	jl.mu.Lock()
+
+	return func() {
+		jl.mu.Unlock()
+
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		if jl.waiters--; jl.waiters == 0 {
+			delete(l.locks, key)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package service
+
+import (
+	"context"
+	"sync"
+
+	"github.com/karahiyo/actions-job/adapter"
+)
+
+// jobsAdapterCache reuses the JobsAdapters by project and region, so that dispatches do not build API clients every time
+type jobsAdapterCache struct {
+	adapters map[string]adapter.JobsAdapter
+	// newAdapter creates the adapters on cache misses
+	newAdapter func(ctx context.Context, project, region string) (adapter.JobsAdapter, error)
+	mu         sync.Mutex
+}
+
+func newJobsAdapterCache() *jobsAdapterCache {
+	return &jobsAdapterCache{
+		adapters:   make(map[string]adapter.JobsAdapter),
+		newAdapter: adapter.NewJobsAdapter,
+	}
+}
+
+// get returns the cached adapter for project and region, or creates one
+func (c *jobsAdapterCache) get(_ context.Context, project, region string) (adapter.JobsAdapter, error) {
+	key := project + "/" + region
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if a, ok := c.adapters[key]; ok {
+		return a, nil
+	}
+
+	// the adapter outlives the request creating it, so that its API client must not be bound to the request context
+	a, err := c.newAdapter(context.Background(), project, region)
+	if err != nil {
+		return nil, err
+	}
+	c.adapters[key] = a
+
+	return a, nil
+}
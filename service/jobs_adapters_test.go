@@ -0,0 +1,36 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/karahiyo/actions-job/adapter"
+)
+
+func TestJobsAdapterCache(t *testing.T) {
+	c := newJobsAdapterCache()
+	created := 0
+	c.newAdapter = func(ctx context.Context, project, region string) (adapter.JobsAdapter, error) {
+		if ctx.Err() != nil {
+			t.Error("adapter is created with a canceled context")
+		}
+		created++
+		return &fakeJobsAdapter{}, nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	a1, _ := c.get(ctx, "project", "us-central1")
+	a2, _ := c.get(ctx, "project", "us-central1")
+	if _, err := c.get(ctx, "project", "asia-northeast1"); err != nil {
+		t.Fatalf("get() error = %v", err)
+	}
+
+	if a1 != a2 {
+		t.Error("get() returned different adapters for the same project and region")
+	}
+	if created != 2 {
+		t.Errorf("created %d adapters, want 2", created)
+	}
+}
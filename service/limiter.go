@@ -0,0 +1,259 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/karahiyo/actions-job/config"
+	"github.com/karahiyo/actions-job/metrics"
+)
+
+// limitKey identifies the scopes a dispatched execution counts against
+type limitKey struct {
+	owner    string
+	repo     string
+	manifest string
+}
+
+func (k limitKey) fullName() string {
+	return k.owner + "/" + k.repo
+}
+
+func (k limitKey) manifestName() string {
+	return k.fullName() + ":" + k.manifest
+}
+
+// lease is an execution counted against the limits until its workflow job completes or the lease expires
+type lease struct {
+	acquiredAt time.Time
+	key        limitKey
+}
+
+// concurrencyLimiter limits the number of concurrent executions globally, per org, per repo and per manifest.
+// Leases are held by workflow job ID, so that only the completed event of the workflow job releases its lease.
+// Dispatch requests exceeding the limits wait in a queue, in FIFO order per repository.
+type concurrencyLimiter struct {
+	orgs      map[string]int
+	repos     map[string]int
+	manifests map[string]int
+	// leases are the leases by workflow job ID
+	leases map[int64]lease
+	// limits returns the current limits, so that config reloads take effect
+	limits func() config.LimitsConfig
+	queue  []dispatchRequest
+	global int
+	mu     sync.Mutex
+}
+
+// VerifySingleInstance checks that the Cloud Run service of the controller runs at most one instance when the
+// concurrency limits are set, since the leases are held in memory by each instance.
+// It does nothing without the limits or outside of Cloud Run.
+func (c *Controller) VerifySingleInstance(ctx context.Context) error {
+	gcpConf := config.GetGCPConfig()
+	if !config.GetLimitsConfig().Enabled() || gcpConf.Service == "" {
+		return nil
+	}
+
+	n, err := c.getMaxInstances(ctx, gcpConf.ProjectID, gcpConf.Region, gcpConf.Service)
+	if err != nil {
+		return fmt.Errorf("failed to get max instances of the controller: %w", err)
+	}
+	if n != 1 {
+		return fmt.Errorf("the concurrency limits require the max instances of 1: service=%s, max instances=%d", gcpConf.Service, n)
+	}
+
+	return nil
+}
+
+func newConcurrencyLimiter() *concurrencyLimiter {
+	return &concurrencyLimiter{
+		orgs:      make(map[string]int),
+		repos:     make(map[string]int),
+		manifests: make(map[string]int),
+		leases:    make(map[int64]lease),
+		limits:    config.GetLimitsConfig,
+	}
+}
+
+// acquireOrEnqueue acquires a lease for req, or appends req to the queue if the limits are reached.
+// It returns true if the lease was acquired.
+func (l *concurrencyLimiter) acquireOrEnqueue(req dispatchRequest, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// dispatch requests of the same repository waiting in the queue go first,
+	// while the ones of the other repositories do not block req
+	if !l.queuedRepo(req.key().fullName()) && l.tryAcquire(req.jobID(), req.key(), now) {
+		return true
+	}
+
+	if l.queued(req.jobID()) {
+		// redelivered event
+		return false
+	}
+	l.queue = append(l.queue, req)
+	metrics.SetDispatchQueueLength(len(l.queue))
+
+	return false
+}
+
+// tracks reports whether the workflow job holds a lease or waits in the queue
+func (l *concurrencyLimiter) tracks(jobID int64) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	_, ok := l.leases[jobID]
+	return ok || l.queued(jobID)
+}
+
+// requeue releases the lease of req, and puts req back at the head of the queue.
+// It is not dispatched from the queue before notBefore.
+func (l *concurrencyLimiter) requeue(req dispatchRequest, notBefore time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.remove(req.jobID())
+	req.notBefore = notBefore
+	l.queue = append([]dispatchRequest{req}, l.queue...)
+	metrics.SetDispatchQueueLength(len(l.queue))
+}
+
+// release releases the lease of the workflow job, or removes it from the queue if it is waiting there,
+// e.g. cancelled while queued. It does nothing for workflow jobs without leases.
+func (l *concurrencyLimiter) release(jobID int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.remove(jobID)
+
+	for i, queued := range l.queue {
+		if queued.jobID() == jobID {
+			l.queue = append(l.queue[:i], l.queue[i+1:]...)
+			metrics.SetDispatchQueueLength(len(l.queue))
+			break
+		}
+	}
+}
+
+// expire releases the leases acquired before now - ttl
+func (l *concurrencyLimiter) expire(now time.Time, ttl time.Duration) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var expired []int64
+	for id, ls := range l.leases {
+		if now.Sub(ls.acquiredAt) >= ttl {
+			expired = append(expired, id)
+		}
+	}
+	for _, id := range expired {
+		l.remove(id)
+	}
+
+	return len(expired)
+}
+
+// popRunnable removes the queued requests that fit in the limits from the queue, in FIFO order.
+// Leases are acquired for the returned requests. Requests deferred until later stay in the queue.
+func (l *concurrencyLimiter) popRunnable(now time.Time) []dispatchRequest {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var runnable []dispatchRequest
+	remaining := l.queue[:0]
+	for _, req := range l.queue {
+		if !now.Before(req.notBefore) && l.tryAcquire(req.jobID(), req.key(), now) {
+			runnable = append(runnable, req)
+		} else {
+			remaining = append(remaining, req)
+		}
+	}
+	l.queue = remaining
+	metrics.SetDispatchQueueLength(len(l.queue))
+
+	return runnable
+}
+
+// drainQueue removes and returns all queued events
+func (l *concurrencyLimiter) drainQueue() []sourcedEvent {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	events := make([]sourcedEvent, 0, len(l.queue))
+	for _, req := range l.queue {
+		events = append(events, sourcedEvent{source: req.source, event: req.event})
+	}
+	l.queue = nil
+	metrics.SetDispatchQueueLength(0)
+
+	return events
+}
+
+func (l *concurrencyLimiter) queued(jobID int64) bool {
+	for _, queued := range l.queue {
+		if queued.jobID() == jobID {
+			return true
+		}
+	}
+	return false
+}
+
+// queuedRepo reports whether a dispatch request of the repository waits in the queue
+func (l *concurrencyLimiter) queuedRepo(fullName string) bool {
+	for _, queued := range l.queue {
+		if queued.key().fullName() == fullName {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *concurrencyLimiter) tryAcquire(jobID int64, key limitKey, now time.Time) bool {
+	if _, ok := l.leases[jobID]; ok {
+		return true
+	}
+
+	limits := l.limits()
+	if exceeds(l.global, limits.Global) ||
+		exceeds(l.orgs[key.owner], limits.OrgLimit(key.owner)) ||
+		exceeds(l.repos[key.fullName()], limits.RepoLimit(key.fullName())) ||
+		exceeds(l.manifests[key.manifestName()], limits.PerManifest) {
+		return false
+	}
+
+	l.global++
+	l.orgs[key.owner]++
+	l.repos[key.fullName()]++
+	l.manifests[key.manifestName()]++
+	l.leases[jobID] = lease{key: key, acquiredAt: now}
+
+	return true
+}
+
+// remove releases the lease of the workflow job if it holds one
+func (l *concurrencyLimiter) remove(jobID int64) {
+	target, ok := l.leases[jobID]
+	if !ok {
+		return
+	}
+	delete(l.leases, jobID)
+
+	l.global--
+	decrement(l.orgs, target.key.owner)
+	decrement(l.repos, target.key.fullName())
+	decrement(l.manifests, target.key.manifestName())
+}
+
+// exceeds reports whether another execution exceeds limit. Zero means unlimited.
+func exceeds(current, limit int) bool {
+	return limit > 0 && current >= limit
+}
+
+func decrement(counts map[string]int, key string) {
+	counts[key]--
+	if counts[key] <= 0 {
+		delete(counts, key)
+	}
+}
@@ -0,0 +1,172 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/google/go-github/v52/github"
+	"github.com/karahiyo/actions-job/config"
+)
+
+func newTestDispatchRequest(id int64, owner, repo, manifest string) dispatchRequest {
+	event := newTestEvent(id)
+	event.Repo.FullName = github.String(owner + "/" + repo)
+
+	return dispatchRequest{
+		event:       event,
+		owner:       owner,
+		repo:        repo,
+		labeledOpts: labeledOptions{jobManifest: manifest},
+	}
+}
+
+func TestConcurrencyLimiter(t *testing.T) {
+	l := newConcurrencyLimiter()
+	l.limits = func() config.LimitsConfig {
+		return config.LimitsConfig{
+			Global:  3,
+			PerRepo: 2,
+			Repos:   map[string]int{"org/big": 3},
+		}
+	}
+	now := time.Now()
+
+	// repo limit
+	if !l.acquireOrEnqueue(newTestDispatchRequest(1, "org", "a", "job.yaml"), now) {
+		t.Fatal("1st dispatch for org/a is not acquired")
+	}
+	if !l.acquireOrEnqueue(newTestDispatchRequest(2, "org", "a", "job.yaml"), now) {
+		t.Fatal("2nd dispatch for org/a is not acquired")
+	}
+	if l.acquireOrEnqueue(newTestDispatchRequest(3, "org", "a", "job.yaml"), now) {
+		t.Fatal("3rd dispatch for org/a is acquired beyond the repo limit")
+	}
+
+	// the queued dispatch for org/a does not block the other repositories, and redelivered events are not queued twice
+	if !l.acquireOrEnqueue(newTestDispatchRequest(4, "org", "big", "job.yaml"), now) {
+		t.Fatal("dispatch for org/big is blocked by the queued dispatch for org/a")
+	}
+	l.acquireOrEnqueue(newTestDispatchRequest(3, "org", "a", "job.yaml"), now)
+	if len(l.queue) != 1 {
+		t.Fatalf("queue length = %d, want 1", len(l.queue))
+	}
+
+	// org/a is still at the repo limit
+	if runnable := l.popRunnable(now); len(runnable) != 0 {
+		t.Fatalf("popRunnable() = %v, want none", runnable)
+	}
+
+	// completed event for org/a frees the repo and global capacity
+	l.release(1)
+	runnable := l.popRunnable(now)
+	if len(runnable) != 1 || runnable[0].event.GetWorkflowJob().GetID() != 3 {
+		t.Fatalf("popRunnable() = %v, want the queued dispatch for org/a", runnable)
+	}
+	if len(l.queue) != 0 {
+		t.Errorf("queue length = %d, want 0", len(l.queue))
+	}
+
+	// workflow job cancelled while queued leaves the queue without freeing the capacity of others
+	if l.acquireOrEnqueue(newTestDispatchRequest(5, "org", "c", "job.yaml"), now) {
+		t.Fatal("dispatch for org/c is acquired beyond the global limit")
+	}
+	l.release(5)
+	if len(l.queue) != 0 || l.global != 3 {
+		t.Errorf("after cancelled while queued: queue length = %d, global = %d, want 0 and 3", len(l.queue), l.global)
+	}
+
+	// completed event of a workflow job without a lease frees nothing
+	l.release(99)
+	if l.global != 3 || l.repos["org/a"] != 2 {
+		t.Errorf("after completed without a lease: global = %d, org/a = %d, want 3 and 2", l.global, l.repos["org/a"])
+	}
+
+	// requests deferred by the rate limit are held until the reset
+	deferred := newTestDispatchRequest(6, "org", "c", "job.yaml")
+	l.requeue(deferred, now.Add(time.Minute))
+	l.release(2)
+	if runnable := l.popRunnable(now); len(runnable) != 0 {
+		t.Errorf("popRunnable() = %v before the reset, want none", runnable)
+	}
+	if runnable := l.popRunnable(now.Add(time.Minute)); len(runnable) != 1 || runnable[0].jobID() != 6 {
+		t.Errorf("popRunnable() = %v after the reset, want the deferred dispatch", runnable)
+	}
+
+	// expired leases free the capacity
+	if n := l.expire(now.Add(2*time.Hour), time.Hour); n != 3 {
+		t.Errorf("expire() = %d, want 3", n)
+	}
+	if l.global != 0 || len(l.leases) != 0 || len(l.repos) != 0 {
+		t.Errorf("counts remain after all leases expired: global=%d, leases=%v, repos=%v", l.global, l.leases, l.repos)
+	}
+}
+
+func TestController_ReceiveWorkflowJobEventSkipsDuplicates(t *testing.T) {
+	loadTestConfig(t)
+	c := &Controller{
+		validate:   validator.New(),
+		limiter:    newConcurrencyLimiter(),
+		pools:      newWarmPools(),
+		dispatched: newDispatchRecords(),
+	}
+	c.limiter.limits = func() config.LimitsConfig {
+		return config.LimitsConfig{Global: 1}
+	}
+	// another workflow job uses up the capacity
+	c.limiter.acquireOrEnqueue(newTestDispatchRequest(1, "owner", "repo", "job.yaml"), time.Now())
+
+	event := newTestEvent(2)
+	event.Repo.Private = github.Bool(true)
+	event.WorkflowJob.Labels = []string{"self-hosted", "job-manifest=job.yaml"}
+
+	if err := c.ReceiveWorkflowJobEvent(context.Background(), "org", event); !errors.Is(err, ErrQueued) {
+		t.Fatalf("ReceiveWorkflowJobEvent() error = %v, want ErrQueued", err)
+	}
+	// the same workflow job delivered by the webhook of another source
+	if err := c.ReceiveWorkflowJobEvent(context.Background(), "repo", event); !errors.Is(err, ErrNonTargetEvent) {
+		t.Errorf("ReceiveWorkflowJobEvent() error = %v for the duplicate, want ErrNonTargetEvent", err)
+	}
+	if len(c.limiter.queue) != 1 || c.limiter.queue[0].source != "org" {
+		t.Errorf("queue = %v, want only the first delivery", c.limiter.queue)
+	}
+}
+
+func TestController_VerifySingleInstance(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("limits: {perRepo: 2}"), 0o600); err != nil {
+		t.Fatalf("failed to write config file: %v", err)
+	}
+	t.Setenv("CONFIG_FILE", path)
+	t.Setenv("K_SERVICE", "actions-job-controller")
+	loadTestConfig(t)
+
+	tests := []struct {
+		name         string
+		maxInstances int
+		wantErr      bool
+	}{
+		{name: "single instance", maxInstances: 1},
+		{name: "multiple instances", maxInstances: 3, wantErr: true},
+		{name: "max instances not set", maxInstances: 0, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Controller{
+				getMaxInstances: func(_ context.Context, _, _, name string) (int, error) {
+					if name != "actions-job-controller" {
+						t.Errorf("service name = %s, want actions-job-controller", name)
+					}
+					return tt.maxInstances, nil
+				},
+			}
+			if err := c.VerifySingleInstance(context.Background()); (err != nil) != tt.wantErr {
+				t.Errorf("VerifySingleInstance() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,406 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v52/github"
+	"github.com/karahiyo/actions-job/adapter"
+	"github.com/karahiyo/actions-job/config"
+	"github.com/rs/zerolog"
+)
+
+const (
+	// poolClaimTTL is how long a queued workflow job claiming an idle runner is expected to wait for it
+	poolClaimTTL = 5 * time.Minute
+	// poolRegistrationTimeout is how long to wait for a started execution to register its runner to GitHub
+	poolRegistrationTimeout = 10 * time.Minute
+)
+
+// poolRunner is an execution started for a pool
+type poolRunner struct {
+	execution dispatchedExecution
+	startedAt time.Time
+}
+
+// poolClaim is a queued workflow job expected to be picked up by an idle runner in the pool
+type poolClaim struct {
+	claimedAt time.Time
+	jobID     int64
+}
+
+// warmPool is the state of a pool. Runners are removed from the pool once they pick up a workflow job.
+type warmPool struct {
+	config config.PoolConfig
+	// runners are keyed by the runner name, which is the execution name
+	runners map[string]poolRunner
+	claims  []poolClaim
+}
+
+// idle returns the number of runners not claimed by queued workflow jobs
+func (p *warmPool) idle() int {
+	return len(p.runners) - len(p.claims)
+}
+
+// warmPools keeps idle runners registered for the configured pools
+type warmPools struct {
+	pools map[string]*warmPool
+	// served are the workflow jobs that claimed runners in the pools instead of acquiring concurrency leases
+	served map[int64]time.Time
+	// configs returns the current pool configs, so that config reloads take effect
+	configs func() []config.PoolConfig
+	wakeCh  chan struct{}
+	mu      sync.Mutex
+}
+
+func newWarmPools() *warmPools {
+	return &warmPools{
+		pools:   make(map[string]*warmPool),
+		served:  make(map[int64]time.Time),
+		configs: config.GetPoolsConfig,
+		wakeCh:  make(chan struct{}, 1),
+	}
+}
+
+// wake requests reconciling the pools without waiting for the next interval
+func (w *warmPools) wake() {
+	select {
+	case w.wakeCh <- struct{}{}:
+	default:
+	}
+}
+
+// sync applies the current configs to the pools and returns the pool names to reconcile.
+// Removed pools are kept with size zero until their runners are cleaned up.
+func (w *warmPools) sync() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	configured := make(map[string]bool)
+	for _, conf := range w.configs() {
+		configured[conf.Name] = true
+		if p, ok := w.pools[conf.Name]; ok {
+			p.config = conf
+			continue
+		}
+		w.pools[conf.Name] = &warmPool{config: conf, runners: make(map[string]poolRunner)}
+	}
+
+	names := make([]string, 0, len(w.pools))
+	for name, p := range w.pools {
+		if !configured[name] {
+			if len(p.runners) == 0 {
+				delete(w.pools, name)
+				continue
+			}
+			p.config.Size = 0
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// snapshot returns the config and a copy of the runners of the pool
+func (w *warmPools) snapshot(name string) (config.PoolConfig, map[string]poolRunner, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	p, ok := w.pools[name]
+	if !ok {
+		return config.PoolConfig{}, nil, false
+	}
+
+	runners := make(map[string]poolRunner, len(p.runners))
+	for k, v := range p.runners {
+		runners[k] = v
+	}
+
+	return p.config, runners, true
+}
+
+// claim claims an idle runner of the first pool registered to the repository of the workflow job,
+// whose labels include all labels of the workflow job. It returns the name of the pool.
+func (w *warmPools) claim(jobID int64, repository string, labels []string, now time.Time) (string, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for _, conf := range w.configs() {
+		p, ok := w.pools[conf.Name]
+		// the runners only pick up workflow jobs of the repository they are registered to
+		if !ok || p.idle() <= 0 || !strings.EqualFold(conf.Repository, repository) || !includeLabels(conf.Labels, labels) {
+			continue
+		}
+
+		for _, c := range p.claims {
+			if c.jobID == jobID {
+				// redelivered event
+				return conf.Name, true
+			}
+		}
+		p.claims = append(p.claims, poolClaim{jobID: jobID, claimedAt: now})
+		w.served[jobID] = now
+
+		return conf.Name, true
+	}
+
+	return "", false
+}
+
+// consume removes the runner that picked up the workflow job from its pool.
+// It returns the name of the pool, or false if the runner is not in any pool.
+func (w *warmPools) consume(runnerName string, jobID int64) (string, poolRunner, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for name, p := range w.pools {
+		r, ok := p.runners[runnerName]
+		if !ok {
+			continue
+		}
+		delete(p.runners, runnerName)
+
+		// the runner may pick up a workflow job other than the claimed ones, then the oldest claim is released
+		i := 0
+		for j, c := range p.claims {
+			if c.jobID == jobID {
+				i = j
+				break
+			}
+		}
+		if len(p.claims) > 0 {
+			p.claims = append(p.claims[:i], p.claims[i+1:]...)
+		}
+
+		return name, r, true
+	}
+
+	return "", poolRunner{}, false
+}
+
+// complete forgets the workflow job, and reports whether it claimed a runner in the pools
+func (w *warmPools) complete(jobID int64) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	_, ok := w.served[jobID]
+	delete(w.served, jobID)
+
+	return ok
+}
+
+func (w *warmPools) add(name, runnerName string, r poolRunner) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if p, ok := w.pools[name]; ok {
+		p.runners[runnerName] = r
+	}
+}
+
+func (w *warmPools) remove(name, runnerName string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if p, ok := w.pools[name]; ok {
+		delete(p.runners, runnerName)
+	}
+}
+
+// expire releases the claims older than poolClaimTTL, and forgets the served workflow jobs older than servedTTL
+func (w *warmPools) expire(now time.Time, servedTTL time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for _, p := range w.pools {
+		remaining := p.claims[:0]
+		for _, c := range p.claims {
+			if now.Sub(c.claimedAt) < poolClaimTTL {
+				remaining = append(remaining, c)
+			}
+		}
+		p.claims = remaining
+	}
+
+	for id, at := range w.served {
+		if now.Sub(at) >= servedTTL {
+			delete(w.served, id)
+		}
+	}
+}
+
+// shortage returns the number of runners to start to fill the pool
+func (w *warmPools) shortage(name string) int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	p, ok := w.pools[name]
+	if !ok {
+		return 0
+	}
+
+	return p.config.Size - p.idle()
+}
+
+// includeLabels reports whether all labels are included in poolLabels, ignoring case
+func includeLabels(poolLabels, labels []string) bool {
+	for _, label := range labels {
+		found := false
+		for _, l := range poolLabels {
+			if strings.EqualFold(l, label) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+const poolCheckInterval = 30 * time.Second
+
+// RunPools keeps the pools filled with idle runners, and replaces the runners idle longer than the TTL, until ctx is done.
+// Runners left in the pools are not tracked by the next instance, and exit on the timeout of their executions.
+func (c *Controller) RunPools(ctx context.Context) {
+	ticker := time.NewTicker(poolCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		c.reconcilePools(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-c.pools.wakeCh:
+		}
+	}
+}
+
+func (c *Controller) reconcilePools(ctx context.Context) {
+	logger := zerolog.Ctx(ctx)
+
+	c.pools.expire(time.Now(), config.GetServerConfig().ConcurrencyLeaseTTL)
+
+	for _, name := range c.pools.sync() {
+		if err := c.reconcilePool(ctx, name); err != nil {
+			logger.Error().Err(err).Str("pool", name).Msg("failed to reconcile pool")
+		}
+	}
+}
+
+// reconcilePool removes the runners that picked up workflow jobs, failed to register or idled past the TTL from the pool,
+// and starts new runners to fill it
+func (c *Controller) reconcilePool(ctx context.Context, name string) error {
+	logger := zerolog.Ctx(ctx).With().Str("pool", name).Logger()
+
+	conf, runners, ok := c.pools.snapshot(name)
+	if !ok {
+		return nil
+	}
+
+	labeledOpts := getOptionsFromLabels(conf.Labels)
+	if !includeSelfHostedLabel(conf.Labels) || labeledOpts.jobManifest == "" {
+		return fmt.Errorf("labels must include \"self-hosted\" and \"job-manifest\": labels=%v", conf.Labels)
+	}
+
+	if len(runners) > 0 {
+		registered, err := c.gitHub().ListRunners(ctx, conf.Owner(), conf.Repo())
+		if err != nil {
+			return fmt.Errorf("failed to list runners: %w", err)
+		}
+
+		byName := make(map[string]*github.Runner, len(registered))
+		for _, r := range registered {
+			byName[r.GetName()] = r
+		}
+
+		now := time.Now()
+		excess := -c.pools.shortage(name)
+		for runnerName, r := range runners {
+			gr, ok := byName[runnerName]
+			switch {
+			case ok && gr.GetBusy():
+				// the in_progress event has not been received yet or was missed
+				c.pools.consume(runnerName, 0)
+			case ok && (excess > 0 || conf.IdleTTL > 0 && now.Sub(r.startedAt) >= time.Duration(conf.IdleTTL)):
+				if err := c.removePoolRunner(ctx, conf, r, gr.GetID()); err != nil {
+					logger.Error().Err(err).Str("runner", runnerName).Msg("failed to remove idle runner")
+					continue
+				}
+				c.pools.remove(name, runnerName)
+				excess--
+				logger.Info().Str("runner", runnerName).Msg("removed idle runner from the pool")
+			case !ok && now.Sub(r.startedAt) >= poolRegistrationTimeout:
+				// the execution failed before registering the runner, or the runner exited after a job
+				c.pools.remove(name, runnerName)
+				logger.Warn().Str("runner", runnerName).Msg("runner is not registered to github, forgetting it")
+			}
+		}
+	}
+
+	for i := c.pools.shortage(name); i > 0; i-- {
+		req := dispatchRequest{
+			owner:       conf.Owner(),
+			repo:        conf.Repo(),
+			ref:         conf.Ref,
+			labeledOpts: labeledOpts,
+			labels:      conf.Labels,
+		}
+
+		// runners in the pools do not count against the concurrency limits
+		execution, err := c.dispatch(logger.WithContext(ctx), req)
+		if err != nil {
+			return fmt.Errorf("failed to start runner for the pool: %w", err)
+		}
+		c.pools.add(name, execution.name, poolRunner{execution: *execution, startedAt: time.Now()})
+		logger.Info().Str("runner", execution.name).Msg("started idle runner for the pool")
+	}
+
+	return nil
+}
+
+// removePoolRunner deregisters the idle runner so that no workflow job is assigned to it, then cancels its execution
+func (c *Controller) removePoolRunner(ctx context.Context, conf config.PoolConfig, r poolRunner, runnerID int64) error {
+	// busy runners can not be removed
+	if err := c.gitHub().RemoveRunner(ctx, conf.Owner(), conf.Repo(), runnerID); err != nil {
+		return err
+	}
+
+	jobsAdapter, err := c.newJobsAdapter(ctx, r.execution.project, r.execution.region)
+	if err != nil {
+		return fmt.Errorf("failed to initialize jobs client: %w", err)
+	}
+
+	if err := jobsAdapter.CancelExecution(ctx, r.execution.name); err != nil && !errors.Is(err, adapter.ErrJobNotFound) {
+		return fmt.Errorf("failed to cancel execution: %w", err)
+	}
+
+	return nil
+}
+
+// receiveInProgressEvent removes the runner that picked up the workflow job from its pool, and fills the pool
+func (c *Controller) receiveInProgressEvent(ctx context.Context, event *github.WorkflowJobEvent) error {
+	runnerName := event.GetWorkflowJob().GetRunnerName()
+
+	pool, r, ok := c.pools.consume(runnerName, event.GetWorkflowJob().GetID())
+	if !ok {
+		c.executions.started(event.GetWorkflowJob().GetID(), runnerName)
+		return fmt.Errorf("runner is not in the pools: runner=%s, %w", runnerName, ErrNonTargetEvent)
+	}
+	c.executions.record(event, r.execution, r.startedAt)
+	c.executions.started(event.GetWorkflowJob().GetID(), runnerName)
+	zerolog.Ctx(ctx).Info().Str("pool", pool).Str("runner", runnerName).Msg("idle runner in the pool picked up the workflow job")
+	c.pools.wake()
+
+	return nil
+}
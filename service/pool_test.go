@@ -0,0 +1,86 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/karahiyo/actions-job/config"
+)
+
+func TestWarmPools(t *testing.T) {
+	w := newWarmPools()
+	w.configs = func() []config.PoolConfig {
+		return []config.PoolConfig{
+			{Name: "small", Repository: "org/a", Labels: []string{"self-hosted", "job-manifest=small.yaml"}, Size: 2},
+		}
+	}
+	now := time.Now()
+
+	if names := w.sync(); len(names) != 1 || names[0] != "small" {
+		t.Fatalf("sync() = %v, want [small]", names)
+	}
+	if n := w.shortage("small"); n != 2 {
+		t.Fatalf("shortage() = %d, want 2", n)
+	}
+	w.add("small", "runner-1", poolRunner{startedAt: now})
+	w.add("small", "runner-2", poolRunner{startedAt: now})
+
+	// labels not included in the pool labels
+	if _, ok := w.claim(1, "org/a", []string{"self-hosted", "job-manifest=large.yaml"}, now); ok {
+		t.Fatal("claim() = true for labels not in the pool")
+	}
+
+	// workflow jobs of other repositories cannot be picked up by the runners in the pool
+	if _, ok := w.claim(4, "org/b", []string{"self-hosted", "job-manifest=small.yaml"}, now); ok {
+		t.Fatal("claim() = true for the repository other than the pool's")
+	}
+
+	// redelivered events claim a runner once
+	for i := 0; i < 2; i++ {
+		if pool, ok := w.claim(2, "Org/A", []string{"Self-Hosted", "job-manifest=small.yaml"}, now); !ok || pool != "small" {
+			t.Fatalf("claim() = %s, %v, want small, true", pool, ok)
+		}
+	}
+	if n := w.shortage("small"); n != 1 {
+		t.Fatalf("shortage() after claim = %d, want 1", n)
+	}
+
+	// the runner picked up the claimed workflow job
+	if pool, _, ok := w.consume("runner-1", 2); !ok || pool != "small" {
+		t.Fatalf("consume() = %s, %v, want small, true", pool, ok)
+	}
+	if _, _, ok := w.consume("runner-1", 2); ok {
+		t.Fatal("consume() = true for the consumed runner")
+	}
+	if n := w.shortage("small"); n != 1 {
+		t.Fatalf("shortage() after consume = %d, want 1", n)
+	}
+
+	// completed workflow jobs served by the pools do not release leases
+	if !w.complete(2) {
+		t.Error("complete() = false for the claimed workflow job")
+	}
+	if w.complete(1) {
+		t.Error("complete() = true for the workflow job not claimed")
+	}
+
+	// claims expire
+	w.claim(3, "org/a", []string{"self-hosted"}, now)
+	w.expire(now.Add(poolClaimTTL), time.Hour)
+	if n := w.shortage("small"); n != 1 {
+		t.Fatalf("shortage() after expire = %d, want 1", n)
+	}
+
+	// removed pools are drained
+	w.configs = func() []config.PoolConfig { return nil }
+	if names := w.sync(); len(names) != 1 {
+		t.Fatalf("sync() = %v, want the removed pool with runners", names)
+	}
+	if n := w.shortage("small"); n != -1 {
+		t.Fatalf("shortage() of the removed pool = %d, want -1", n)
+	}
+	w.remove("small", "runner-2")
+	if names := w.sync(); len(names) != 0 {
+		t.Fatalf("sync() = %v, want the removed pool to be deleted", names)
+	}
+}
@@ -0,0 +1,117 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/karahiyo/actions-job/adapter"
+	"github.com/karahiyo/actions-job/config"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+const queueCheckInterval = 30 * time.Second
+
+// RunQueue periodically expires the leases of executions whose completed events were missed,
+// and dispatches the queued workflow jobs that fit in the freed capacity, until ctx is done.
+func (c *Controller) RunQueue(ctx context.Context) {
+	logger := zerolog.Ctx(ctx)
+
+	ticker := time.NewTicker(queueCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if n := c.limiter.expire(time.Now(), config.GetServerConfig().ConcurrencyLeaseTTL); n > 0 {
+			logger.Warn().Msgf("expired %d leases without completed events", n)
+		}
+		c.dispatchQueued(ctx)
+	}
+}
+
+// dispatchQueued dispatches the queued workflow jobs that fit in the limits in the background
+func (c *Controller) dispatchQueued(ctx context.Context) {
+	for _, req := range c.limiter.popRunnable(time.Now()) {
+		logger := dispatchLogger(req)
+
+		if req.source == "" && c.installations.suspended(req.event.GetInstallation().GetID()) {
+			c.limiter.release(req.jobID())
+			logger.Warn().Msg("dropped queued workflow job of the suspended installation")
+			continue
+		}
+
+		if err := c.inflight.begin(req.source, req.event); err != nil {
+			if errors.Is(err, ErrShuttingDown) {
+				c.limiter.release(req.jobID())
+				if err := c.saveUnfinished(ctx, sourcedEvent{source: req.source, event: req.event}); err != nil {
+					logger.Error().Err(err).Msg("failed to save queued workflow job while shutting down")
+				}
+				continue
+			}
+			// a redelivery of the workflow job is being checked, and it is rejected since the workflow job holds the lease
+			c.limiter.requeue(req, time.Time{})
+			continue
+		}
+
+		c.dispatchAsync(req)
+	}
+}
+
+// dispatchAsync dispatches req in the background, batched with the other requests for the same labels if
+// ServerConfig.BatchWindow is set. The context is detached from the request receiving req, which has already finished.
+// req must be in flight, and it ends when the dispatch finishes.
+func (c *Controller) dispatchAsync(req dispatchRequest) {
+	ctx := dispatchLogger(req).WithContext(context.Background())
+
+	conf := config.GetServerConfig()
+	if conf.BatchWindow > 0 {
+		c.batcher.add(ctx, req, conf.BatchWindow, conf.BatchMaxSize, c.dispatch, c.finishDispatch)
+		return
+	}
+
+	go func() {
+		execution, err := c.dispatch(ctx, req)
+		c.finishDispatch(req, execution, err)
+	}()
+}
+
+// finishDispatch handles the result of the background dispatch of req like a webhook response
+func (c *Controller) finishDispatch(req dispatchRequest, execution *dispatchedExecution, err error) {
+	defer c.inflight.end(req.event)
+
+	logger := dispatchLogger(req)
+	ctx := logger.WithContext(context.Background())
+
+	if err != nil {
+		var limitErr *adapter.RateLimitError
+		if errors.As(err, &limitErr) {
+			c.limiter.requeue(req, limitErr.Reset)
+			logger.Warn().Err(err).Msg("deferred workflow job until the github api rate limit resets")
+			return
+		}
+
+		c.limiter.release(req.jobID())
+		logger.Error().Err(err).Msg("failed to dispatch workflow job in the background")
+		if adapter.IsPermanent(err) {
+			c.reportFailure(ctx, req.source, req.event, fmt.Errorf("%w, %w", err, ErrPermanent))
+		}
+		return
+	}
+	c.dispatched.record(req.jobID(), time.Now())
+	c.logExecution(ctx, c.executions.record(req.event, *execution, time.Now()))
+}
+
+func dispatchLogger(req dispatchRequest) zerolog.Logger {
+	return log.Logger.With().
+		Str("repo", req.event.GetRepo().GetFullName()).
+		Int64("workflow_job_id", req.jobID()).
+		Int64("run_id", req.event.GetWorkflowJob().GetRunID()).
+		Logger()
+}
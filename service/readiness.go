@@ -0,0 +1,46 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/karahiyo/actions-job/adapter"
+	"github.com/karahiyo/actions-job/config"
+)
+
+// readinessCache caches the result of the readiness checks so that frequent probes do not hit GitHub and Google APIs
+type readinessCache struct {
+	checkedAt time.Time
+	err       error
+	mu        sync.Mutex
+}
+
+// CheckReadiness verifies that the GitHub App and Cloud Run credentials are usable.
+// The result is cached for ServerConfig.ReadinessCacheTTL.
+func (c *Controller) CheckReadiness(ctx context.Context) error {
+	c.readiness.mu.Lock()
+	defer c.readiness.mu.Unlock()
+
+	if !c.readiness.checkedAt.IsZero() && time.Since(c.readiness.checkedAt) < config.GetServerConfig().ReadinessCacheTTL {
+		return c.readiness.err
+	}
+
+	c.readiness.err = c.checkReadiness(ctx)
+	c.readiness.checkedAt = time.Now()
+
+	return c.readiness.err
+}
+
+func (c *Controller) checkReadiness(ctx context.Context) error {
+	if err := c.gitHub().VerifyCredentials(ctx); err != nil {
+		return fmt.Errorf("github app credentials are not usable: %w", err)
+	}
+
+	if err := adapter.VerifyGoogleCredentials(ctx); err != nil {
+		return fmt.Errorf("cloud run credentials are not usable: %w", err)
+	}
+
+	return nil
+}
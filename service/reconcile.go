@@ -0,0 +1,241 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v52/github"
+	"github.com/karahiyo/actions-job/adapter"
+	"github.com/karahiyo/actions-job/config"
+	"github.com/karahiyo/actions-job/metrics"
+	"github.com/rs/zerolog"
+)
+
+// dispatchRecords remembers the workflow jobs handled by the controller, so that the reconciler does not dispatch them again
+type dispatchRecords struct {
+	jobs map[int64]time.Time
+	mu   sync.Mutex
+}
+
+func newDispatchRecords() *dispatchRecords {
+	return &dispatchRecords{jobs: make(map[int64]time.Time)}
+}
+
+func (r *dispatchRecords) record(id int64, now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.jobs[id] = now
+}
+
+func (r *dispatchRecords) has(id int64) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, ok := r.jobs[id]
+	return ok
+}
+
+// expire forgets the records older than ttl, so that the workflow jobs still queued are dispatched again
+func (r *dispatchRecords) expire(now time.Time, ttl time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for id, at := range r.jobs {
+		if now.Sub(at) >= ttl {
+			delete(r.jobs, id)
+		}
+	}
+}
+
+// RunReconciler periodically dispatches the queued workflow jobs whose webhooks were missed, until ctx is done.
+// It does nothing if ServerConfig.ReconcileInterval is zero at startup.
+func (c *Controller) RunReconciler(ctx context.Context) {
+	if config.GetServerConfig().ReconcileInterval == 0 {
+		return
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(config.GetServerConfig().ReconcileInterval):
+		}
+
+		if err := c.reconcile(ctx); err != nil {
+			if errors.Is(err, ErrShuttingDown) {
+				return
+			}
+			zerolog.Ctx(ctx).Error().Err(err).Msg("failed to reconcile queued workflow jobs")
+		}
+	}
+}
+
+// reconcile lists the queued workflow jobs with the "self-hosted" label in the repositories of the installations,
+// and dispatches the ones without dispatch records like webhook events
+func (c *Controller) reconcile(ctx context.Context) error {
+	conf := config.GetServerConfig()
+	now := time.Now()
+	c.dispatched.expire(now, conf.ReconcileRedispatchAfter)
+
+	var errs []error
+	for _, id := range c.reconciledInstallations() {
+		err := c.reconcileInstallation(ctx, id, now)
+		if errors.Is(err, ErrShuttingDown) {
+			return err
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// reconciledInstallations returns the installation of GitHubAppConfig and the other installations of the GitHub App
+// known from the webhook events, except the suspended ones
+func (c *Controller) reconciledInstallations() []int64 {
+	defaultID := config.GetGitHubAppConfig().InstallationID
+
+	var ids []int64
+	if !c.installations.suspended(defaultID) {
+		ids = append(ids, defaultID)
+	}
+	for _, inst := range c.installations.list() {
+		if inst.ID != defaultID && !inst.Suspended {
+			ids = append(ids, inst.ID)
+		}
+	}
+
+	return ids
+}
+
+func (c *Controller) reconcileInstallation(ctx context.Context, installationID int64, now time.Time) error {
+	conf := config.GetServerConfig()
+
+	gh, err := c.gitHubFor("", installationID)
+	if err != nil {
+		return err
+	}
+	repos, err := gh.ListInstallationRepos(ctx)
+	if err != nil {
+		return fmt.Errorf("installation_id=%d, %w", installationID, err)
+	}
+
+	for _, repo := range repos {
+		// dispatches for them are rejected anyway
+		if !repo.GetPrivate() || repo.GetFork() || repo.GetArchived() {
+			continue
+		}
+
+		ownerRepo := strings.Split(repo.GetFullName(), "/")
+		jobs, err := gh.ListQueuedWorkflowJobs(ctx, ownerRepo[0], ownerRepo[1])
+		if err != nil {
+			zerolog.Ctx(ctx).Error().Err(err).Str("repo", repo.GetFullName()).Msg("failed to list queued workflow jobs")
+			continue
+		}
+
+		// idle runners are listed once per repository with the workflow jobs to dispatch
+		var idle []*github.Runner
+		idleListed := false
+		for _, job := range jobs {
+			if !includeSelfHostedLabel(job.Labels) || now.Sub(job.GetCreatedAt().Time) < conf.ReconcileMinAge {
+				continue
+			}
+			if c.dispatched.has(job.GetID()) || c.inflight.has(job.GetID()) {
+				continue
+			}
+
+			// the dispatch records are kept in memory, so that the workflow jobs dispatched before a restart or by
+			// other instances are not known. Their runners are registered and idle once started.
+			if !idleListed {
+				if idle, err = c.idleRunners(ctx, gh, ownerRepo[0], ownerRepo[1]); err != nil {
+					zerolog.Ctx(ctx).Error().Err(err).Str("repo", repo.GetFullName()).Msg("failed to list idle runners")
+					break
+				}
+				idleListed = true
+			}
+			if i := pickRunner(idle, job.Labels); i >= 0 {
+				idle = append(idle[:i], idle[i+1:]...)
+				c.dispatched.record(job.GetID(), now)
+				continue
+			}
+
+			if err := c.reconcileJob(ctx, installationID, repo, job); errors.Is(err, ErrShuttingDown) {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// idleRunners lists the online runners of the repository not running workflow jobs
+func (c *Controller) idleRunners(ctx context.Context, gh adapter.GitHubAdapter, owner, repo string) ([]*github.Runner, error) {
+	runners, err := gh.ListRunners(ctx, owner, repo)
+	if err != nil {
+		return nil, err
+	}
+
+	idle := runners[:0]
+	for _, r := range runners {
+		if r.GetStatus() == "online" && !r.GetBusy() {
+			idle = append(idle, r)
+		}
+	}
+
+	return idle, nil
+}
+
+// pickRunner returns the index of the first runner whose labels include all labels of the workflow job, or -1
+func pickRunner(runners []*github.Runner, labels []string) int {
+	for i, r := range runners {
+		names := make([]string, 0, len(r.Labels))
+		for _, l := range r.Labels {
+			names = append(names, l.GetName())
+		}
+		if includeLabels(names, labels) {
+			return i
+		}
+	}
+
+	return -1
+}
+
+func (c *Controller) reconcileJob(ctx context.Context, installationID int64, repo *github.Repository, job *github.WorkflowJob) error {
+	logger := zerolog.Ctx(ctx).With().
+		Str("repo", repo.GetFullName()).
+		Int64("workflow_job_id", job.GetID()).
+		Int64("run_id", job.GetRunID()).
+		Logger()
+
+	event := &github.WorkflowJobEvent{
+		Action:       github.String("queued"),
+		WorkflowJob:  job,
+		Repo:         repo,
+		Installation: &github.Installation{ID: github.Int64(installationID)},
+	}
+
+	// the reconciler lists the workflow jobs with the installation, so that they are dispatched with its credentials
+	err := c.ReceiveWorkflowJobEvent(logger.WithContext(ctx), "", event)
+	switch {
+	case err == nil || errors.Is(err, ErrQueued):
+		logger.Warn().Msg("dispatched queued workflow job whose webhook was missed")
+	case errors.Is(err, ErrNonTargetEvent) || errors.Is(err, ErrBadRequest) || errors.Is(err, ErrPermanent):
+		// not to check it again until the record expires
+		c.dispatched.record(job.GetID(), time.Now())
+		logger.Debug().Err(err).Msg("skipped queued workflow job")
+		return nil
+	case errors.Is(err, ErrShuttingDown):
+		return err
+	default:
+		logger.Error().Err(err).Msg("failed to dispatch queued workflow job whose webhook was missed")
+	}
+	metrics.IncReconciledJob(err)
+
+	return err
+}
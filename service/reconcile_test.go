@@ -0,0 +1,120 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/google/go-github/v52/github"
+	"github.com/karahiyo/actions-job/adapter"
+	"github.com/karahiyo/actions-job/config"
+)
+
+// fakeReconcileAdapter lists a repository with queued workflow jobs and its runners
+type fakeReconcileAdapter struct {
+	adapter.GitHubAdapter
+
+	repo    *github.Repository
+	jobs    []*github.WorkflowJob
+	runners []*github.Runner
+}
+
+func (f *fakeReconcileAdapter) ListInstallationRepos(context.Context) ([]*github.Repository, error) {
+	return []*github.Repository{f.repo}, nil
+}
+
+func (f *fakeReconcileAdapter) ListQueuedWorkflowJobs(context.Context, string, string) ([]*github.WorkflowJob, error) {
+	return f.jobs, nil
+}
+
+func (f *fakeReconcileAdapter) ListRunners(context.Context, string, string) ([]*github.Runner, error) {
+	return f.runners, nil
+}
+
+func TestController_Reconcile(t *testing.T) {
+	loadTestConfig(t)
+	now := time.Now()
+	labels := []string{"self-hosted", "job-manifest=job.yaml"}
+	newJob := func(age time.Duration, labels ...string) *github.WorkflowJob {
+		return &github.WorkflowJob{
+			ID:        github.Int64(1),
+			Labels:    labels,
+			CreatedAt: &github.Timestamp{Time: now.Add(-age)},
+		}
+	}
+	newRunner := func(status string, busy bool, labels ...string) *github.Runner {
+		r := &github.Runner{Status: github.String(status), Busy: github.Bool(busy)}
+		for _, l := range labels {
+			r.Labels = append(r.Labels, &github.RunnerLabels{Name: github.String(l)})
+		}
+		return r
+	}
+
+	tests := []struct {
+		name string
+		job  *github.WorkflowJob
+		// recordedAt is when the workflow job was dispatched, zero if not
+		recordedAt   time.Time
+		public       bool
+		runners      []*github.Runner
+		wantDispatch bool
+	}{
+		{name: "missed webhook", job: newJob(5*time.Minute, labels...), wantDispatch: true},
+		{name: "younger than the min age", job: newJob(time.Minute, labels...)},
+		{name: "without the self-hosted label", job: newJob(5*time.Minute, "ubuntu-latest")},
+		{name: "public repository", job: newJob(5*time.Minute, labels...), public: true},
+		{name: "dispatched recently", job: newJob(5*time.Minute, labels...), recordedAt: now.Add(-time.Minute)},
+		{name: "dispatched long ago", job: newJob(time.Hour, labels...), recordedAt: now.Add(-time.Hour), wantDispatch: true},
+		{
+			name:    "idle runner with the labels",
+			job:     newJob(5*time.Minute, labels...),
+			runners: []*github.Runner{newRunner("online", false, "Self-Hosted", "job-manifest=job.yaml", "x64")},
+		},
+		{
+			name: "busy, offline or other runners",
+			job:  newJob(5*time.Minute, labels...),
+			runners: []*github.Runner{
+				newRunner("online", true, labels...),
+				newRunner("offline", false, labels...),
+				newRunner("online", false, "self-hosted", "job-manifest=other.yaml"),
+			},
+			wantDispatch: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeReconcileAdapter{
+				repo: &github.Repository{
+					FullName: github.String("owner/repo"),
+					Private:  github.Bool(!tt.public),
+				},
+				jobs:    []*github.WorkflowJob{tt.job},
+				runners: tt.runners,
+			}
+			c := &Controller{
+				ghAdapter:  fake,
+				ghConfig:   config.GetGitHubAppConfig(),
+				validate:   validator.New(),
+				limiter:    newConcurrencyLimiter(),
+				pools:      newWarmPools(),
+				dispatched: newDispatchRecords(),
+			}
+			// dispatches wait in the queue, so that the dispatched workflow jobs are the queued ones
+			c.limiter.limits = func() config.LimitsConfig {
+				return config.LimitsConfig{Global: 1}
+			}
+			c.limiter.acquireOrEnqueue(newTestDispatchRequest(99, "owner", "other", "job.yaml"), now)
+			if !tt.recordedAt.IsZero() {
+				c.dispatched.record(tt.job.GetID(), tt.recordedAt)
+			}
+
+			if err := c.reconcile(context.Background()); err != nil {
+				t.Fatalf("reconcile() error = %v", err)
+			}
+			if dispatched := c.limiter.tracks(tt.job.GetID()); dispatched != tt.wantDispatch {
+				t.Errorf("dispatched = %v, want %v", dispatched, tt.wantDispatch)
+			}
+		})
+	}
+}
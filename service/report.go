@@ -0,0 +1,128 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v52/github"
+	"github.com/karahiyo/actions-job/adapter"
+	"github.com/karahiyo/actions-job/config"
+	"github.com/rs/zerolog"
+)
+
+// checkPermissionRetryInterval is how long failure reports are skipped once the checks:write permission is denied
+const checkPermissionRetryInterval = time.Hour
+
+// failureReporter tracks whether failure reports can be posted with the permissions of the GitHub App of each webhook source
+type failureReporter struct {
+	disabledUntil map[string]time.Time
+	mu            sync.Mutex
+}
+
+func (r *failureReporter) enabled(source string, now time.Time) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return !now.Before(r.disabledUntil[source])
+}
+
+func (r *failureReporter) disable(source string, until time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.disabledUntil == nil {
+		r.disabledUntil = make(map[string]time.Time)
+	}
+	r.disabledUntil[source] = until
+}
+
+// Causes of the reported failures, classified for the check runs without the details of the errors
+var (
+	errRepositoryNotAllowed = errors.New("repository is not allowed to use self-hosted runners")
+	errInvalidLabels        = errors.New("invalid labels")
+	errManifestNotFound     = errors.New("job manifest not found")
+	errInvalidManifest      = errors.New("invalid job manifest")
+)
+
+// failureReason returns a short reason of the failure for the check run. The full error is only logged,
+// since the check runs are visible to everyone with read access to the repository.
+func failureReason(err error) string {
+	switch {
+	case errors.Is(err, errRepositoryNotAllowed):
+		return "The repository is not allowed to use self-hosted runners."
+	case errors.Is(err, errInvalidLabels):
+		return "The labels of the workflow job are invalid."
+	case errors.Is(err, errManifestNotFound):
+		return "The job manifest is not found at the commit of the workflow job."
+	case errors.Is(err, errInvalidManifest):
+		return "The job manifest is invalid."
+	case errors.Is(err, adapter.ErrPermissionDenied):
+		return "The controller is not permitted to start the job."
+	default:
+		return "The job failed to start."
+	}
+}
+
+// isReportedFailure returns whether err is a rejection of the workflow job that developers can fix,
+// e.g. a bad label, a missing manifest or a repository not allowed to use self-hosted runners
+func isReportedFailure(err error) bool {
+	return errors.Is(err, ErrBadRequest) || errors.Is(err, ErrPermanent)
+}
+
+// reportFailure posts a failed check run on the head commit of the workflow job, explaining why no runner was started.
+// Errors are only logged, since the report is best effort.
+func (c *Controller) reportFailure(ctx context.Context, source string, event *github.WorkflowJobEvent, cause error) {
+	logger := zerolog.Ctx(ctx)
+
+	labels := event.GetWorkflowJob().Labels
+	manifest := getOptionsFromLabels(labels).jobManifest
+	// the workflow job does not target the controller
+	if !includeSelfHostedLabel(labels) || manifest == "" {
+		return
+	}
+	if !config.GetGitHubAppConfig().ReportFailures || !c.reporter.enabled(source, time.Now()) {
+		return
+	}
+
+	ownerRepo := strings.Split(event.GetRepo().GetFullName(), "/")
+	job := event.GetWorkflowJob()
+	manifestURL := fmt.Sprintf("%s/blob/%s/%s", event.GetRepo().GetHTMLURL(), job.GetHeadSHA(), manifest)
+
+	gh, err := c.gitHubFor(source, event.GetInstallation().GetID())
+	if err != nil {
+		logger.Error().Err(err).Msg("failed to report the dispatch failure")
+		return
+	}
+	_, err = gh.CreateCheckRun(ctx, ownerRepo[0], ownerRepo[1], github.CreateCheckRunOptions{
+		Name:        fmt.Sprintf("actions-job / %s", job.GetName()),
+		HeadSHA:     job.GetHeadSHA(),
+		DetailsURL:  github.String(job.GetHTMLURL()),
+		ExternalID:  github.String(strconv.FormatInt(job.GetID(), 10)),
+		Status:      github.String("completed"),
+		Conclusion:  github.String("failure"),
+		CompletedAt: &github.Timestamp{Time: time.Now()},
+		Output: &github.CheckRunOutput{
+			Title: github.String("No runner was started"),
+			Summary: github.String(fmt.Sprintf(
+				"No self-hosted runner was started for the workflow job [%s](%s), so that it waits for a runner until it is cancelled.\n\n"+
+					"- Reason: %s See the logs of the controller for details.\n- Job manifest: [%s](%s)",
+				job.GetName(), job.GetHTMLURL(), failureReason(cause), manifest, manifestURL,
+			)),
+		},
+	})
+	if errors.Is(err, adapter.ErrPermissionDenied) {
+		c.reporter.disable(source, time.Now().Add(checkPermissionRetryInterval))
+		logger.Warn().Err(err).Msgf("github app has no checks:write permission, skipping failure reports for %s", checkPermissionRetryInterval)
+		return
+	}
+	if err != nil {
+		logger.Error().Err(err).Msg("failed to report the dispatch failure")
+		return
+	}
+	logger.Info().Err(cause).Msg("reported the dispatch failure with a check run")
+}
@@ -0,0 +1,93 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/google/go-github/v52/github"
+	"github.com/karahiyo/actions-job/adapter"
+)
+
+// fakeCheckRunsAdapter records the created check runs, and fails them with err
+type fakeCheckRunsAdapter struct {
+	adapter.GitHubAdapter
+
+	checkRuns []github.CreateCheckRunOptions
+	err       error
+}
+
+func (f *fakeCheckRunsAdapter) CreateCheckRun(_ context.Context, _, _ string, opts github.CreateCheckRunOptions) (*github.CheckRun, error) {
+	f.checkRuns = append(f.checkRuns, opts)
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &github.CheckRun{}, nil
+}
+
+func newReportTestEvent(labels ...string) *github.WorkflowJobEvent {
+	return &github.WorkflowJobEvent{
+		Action: github.String("queued"),
+		Repo:   &github.Repository{FullName: github.String("owner/repo"), HTMLURL: github.String("https://github.com/owner/repo")},
+		WorkflowJob: &github.WorkflowJob{
+			ID:      github.Int64(1),
+			Name:    github.String("build"),
+			HeadSHA: github.String("0123456789abcdef0123456789abcdef01234567"),
+			Labels:  labels,
+		},
+	}
+}
+
+func TestController_reportFailure(t *testing.T) {
+	loadTestConfig(t)
+	ctx := context.Background()
+	cause := fmt.Errorf("failed to parse job manifest: manifest=secret-content, %w, %w", errInvalidManifest, ErrPermanent)
+
+	t.Run("reports on the head commit", func(t *testing.T) {
+		fake := &fakeCheckRunsAdapter{}
+		c := &Controller{ghAdapter: fake}
+
+		c.reportFailure(ctx, "", newReportTestEvent("self-hosted", "job-manifest=.github/jobs/build.yaml"), cause)
+
+		if len(fake.checkRuns) != 1 {
+			t.Fatalf("check runs = %d, want 1", len(fake.checkRuns))
+		}
+		got := fake.checkRuns[0]
+		if got.HeadSHA != "0123456789abcdef0123456789abcdef01234567" || got.GetConclusion() != "failure" {
+			t.Errorf("check run = %+v, want a failure on the head SHA", got)
+		}
+		wantURL := "https://github.com/owner/repo/blob/0123456789abcdef0123456789abcdef01234567/.github/jobs/build.yaml"
+		if !strings.Contains(got.Output.GetSummary(), wantURL) || !strings.Contains(got.Output.GetSummary(), "The job manifest is invalid.") {
+			t.Errorf("summary = %q, want the reason and %s", got.Output.GetSummary(), wantURL)
+		}
+		if strings.Contains(got.Output.GetSummary(), "secret-content") {
+			t.Errorf("summary = %q, want the details of the error to be left out", got.Output.GetSummary())
+		}
+	})
+
+	t.Run("skips workflow jobs not targeting the controller", func(t *testing.T) {
+		fake := &fakeCheckRunsAdapter{}
+		c := &Controller{ghAdapter: fake}
+
+		c.reportFailure(ctx, "", newReportTestEvent("self-hosted", "linux"), cause)
+
+		if len(fake.checkRuns) != 0 {
+			t.Errorf("check runs = %d, want 0", len(fake.checkRuns))
+		}
+	})
+
+	t.Run("skips reports once the permission is denied", func(t *testing.T) {
+		fake := &fakeCheckRunsAdapter{err: &adapter.APIError{Err: errors.New("resource not accessible by integration"), Kind: adapter.ErrPermissionDenied, StatusCode: 403}}
+		c := &Controller{ghAdapter: fake}
+		event := newReportTestEvent("self-hosted", "job-manifest=.github/jobs/build.yaml")
+
+		c.reportFailure(ctx, "", event, cause)
+		c.reportFailure(ctx, "", event, cause)
+
+		if len(fake.checkRuns) != 1 {
+			t.Errorf("check runs = %d, want 1", len(fake.checkRuns))
+		}
+	})
+}
@@ -0,0 +1,204 @@
+package service
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/karahiyo/actions-job/config"
+	"google.golang.org/api/run/v1"
+)
+
+// Cloud Run defaults for containers without limits
+const (
+	defaultCPU    = "1"
+	defaultMemory = "512Mi"
+)
+
+const (
+	mebibyte = 1 << 20
+	gibibyte = 1 << 30
+)
+
+// containerResources are the CPU and memory limits of the runner container requested by labels.
+// Empty values keep the limits in the job manifest.
+type containerResources struct {
+	cpu    string
+	memory string
+}
+
+// resolveResources resolves the "size", "cpu" and "memory" labels with sizes.
+// "cpu" and "memory" override the ones of "size".
+func resolveResources(opts labeledOptions, sizes map[string]config.SizeConfig) (containerResources, error) {
+	var res containerResources
+
+	if opts.size != "" {
+		size, ok := sizes[opts.size]
+		if !ok {
+			names := make([]string, 0, len(sizes))
+			for name := range sizes {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+
+			return res, fmt.Errorf("unknown size: size=%s, available=%v", opts.size, names)
+		}
+		res = containerResources{cpu: size.CPU, memory: size.Memory}
+	}
+
+	if opts.cpu != "" {
+		res.cpu = opts.cpu
+	}
+	if opts.memory != "" {
+		res.memory = opts.memory
+	}
+
+	// the manifest may have the other one, so that the combination is checked again when applied
+	if res.cpu != "" && res.memory != "" {
+		if err := validateCloudRunResources(res.cpu, res.memory); err != nil {
+			return res, err
+		}
+	}
+
+	return res, nil
+}
+
+// applyResources sets res to the limits of the runner container, and validates the resulting combination.
+// The limits of the manifest are left to Cloud Run without resource labels.
+func applyResources(job *run.Job, res containerResources) error {
+	if res.cpu == "" && res.memory == "" {
+		return nil
+	}
+
+	container := job.Spec.Template.Spec.Template.Spec.Containers[0]
+	if container.Resources == nil {
+		container.Resources = &run.ResourceRequirements{}
+	}
+	if container.Resources.Limits == nil {
+		container.Resources.Limits = make(map[string]string)
+	}
+	limits := container.Resources.Limits
+
+	if res.cpu != "" {
+		limits["cpu"] = res.cpu
+	}
+	if res.memory != "" {
+		limits["memory"] = res.memory
+	}
+
+	cpu, memory := limits["cpu"], limits["memory"]
+	if cpu == "" {
+		cpu = defaultCPU
+	}
+	if memory == "" {
+		memory = defaultMemory
+	}
+
+	return validateCloudRunResources(cpu, memory)
+}
+
+var (
+	cpuPattern    = regexp.MustCompile(`^(\d+(?:\.\d+)?)(m?)$`)
+	memoryPattern = regexp.MustCompile(`^(\d+(?:\.\d+)?)(Ki|Mi|Gi|Ti|k|M|G|T|)$`)
+)
+
+// memoryUnits are the bytes of the memory quantity suffixes
+var memoryUnits = map[string]float64{
+	"":   1,
+	"k":  1e3,
+	"M":  1e6,
+	"G":  1e9,
+	"T":  1e12,
+	"Ki": 1 << 10,
+	"Mi": 1 << 20,
+	"Gi": 1 << 30,
+	"Ti": 1 << 40,
+}
+
+// validateCloudRunResources checks cpu and memory against the combinations allowed by Cloud Run jobs.
+// See https://cloud.google.com/run/docs/configuring/jobs/cpu and https://cloud.google.com/run/docs/configuring/jobs/memory-limits
+func validateCloudRunResources(cpu, memory string) error {
+	millicores, err := parseCPU(cpu)
+	if err != nil {
+		return err
+	}
+	bytes, err := parseMemory(memory)
+	if err != nil {
+		return err
+	}
+
+	var minMemory int64
+	switch {
+	case millicores >= 80 && millicores < 1000:
+	case millicores == 1000, millicores == 2000:
+		minMemory = 512 * mebibyte
+	case millicores == 4000:
+		minMemory = 2 * gibibyte
+	case millicores == 6000, millicores == 8000:
+		minMemory = 4 * gibibyte
+	default:
+		return fmt.Errorf("cpu must be between 0.08 and 1, or one of 2, 4, 6 or 8: cpu=%s", cpu)
+	}
+
+	var minCPU int64
+	switch {
+	case bytes > 32*gibibyte:
+		return fmt.Errorf("memory must not exceed 32Gi: memory=%s", memory)
+	case bytes > 24*gibibyte:
+		minCPU = 8000
+	case bytes > 16*gibibyte:
+		minCPU = 6000
+	case bytes > 8*gibibyte:
+		minCPU = 4000
+	case bytes > 4*gibibyte:
+		minCPU = 2000
+	case bytes > 1*gibibyte:
+		minCPU = 1000
+	case bytes > 512*mebibyte:
+		minCPU = 500
+	}
+
+	if bytes < minMemory {
+		return fmt.Errorf("cpu %s requires at least %dMi memory: memory=%s", cpu, minMemory/mebibyte, memory)
+	}
+	if millicores < minCPU {
+		return fmt.Errorf("memory %s requires at least %g cpu: cpu=%s", memory, float64(minCPU)/1000, cpu)
+	}
+
+	return nil
+}
+
+// parseCPU parses cpu like "2", "0.5" or "2000m" into millicores
+func parseCPU(cpu string) (int64, error) {
+	m := cpuPattern.FindStringSubmatch(cpu)
+	if m == nil {
+		return 0, fmt.Errorf("invalid cpu: cpu=%s", cpu)
+	}
+
+	n, err := strconv.ParseFloat(m[1], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid cpu: cpu=%s, %w", cpu, err)
+	}
+	if m[2] == "m" {
+		return int64(math.Round(n)), nil
+	}
+
+	return int64(math.Round(n * 1000)), nil
+}
+
+// parseMemory parses memory like "512Mi", "1.5Gi" or "4096Ki" into bytes
+func parseMemory(memory string) (int64, error) {
+	m := memoryPattern.FindStringSubmatch(memory)
+	if m == nil {
+		return 0, fmt.Errorf("invalid memory, must be like 512Mi or 4Gi: memory=%s", memory)
+	}
+
+	n, err := strconv.ParseFloat(m[1], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid memory: memory=%s, %w", memory, err)
+	}
+
+	return int64(math.Round(n * memoryUnits[m[2]])), nil
+}
@@ -0,0 +1,83 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/karahiyo/actions-job/config"
+	"google.golang.org/api/run/v1"
+)
+
+func TestValidateCloudRunResources(t *testing.T) {
+	tests := []struct {
+		cpu     string
+		memory  string
+		wantErr bool
+	}{
+		{cpu: "1", memory: "512Mi"},
+		{cpu: "2000m", memory: "8Gi"},
+		{cpu: "4", memory: "16Gi"},
+		{cpu: "8", memory: "32Gi"},
+		{cpu: "3", memory: "4Gi", wantErr: true},
+		{cpu: "4", memory: "1Gi", wantErr: true},
+		{cpu: "1", memory: "8Gi", wantErr: true},
+		{cpu: "4", memory: "20Gi", wantErr: true},
+		{cpu: "8", memory: "64Gi", wantErr: true},
+		{cpu: "1", memory: "1TB", wantErr: true},
+		{cpu: "0.5", memory: "1Gi"},
+		{cpu: "500m", memory: "2Gi", wantErr: true},
+		{cpu: "1", memory: "1.5Gi"},
+		{cpu: "2", memory: "4194304Ki"},
+		{cpu: "1.5", memory: "2Gi", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		err := validateCloudRunResources(tt.cpu, tt.memory)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("validateCloudRunResources(%s, %s) error = %v, wantErr %v", tt.cpu, tt.memory, err, tt.wantErr)
+		}
+	}
+}
+
+func TestResolveAndApplyResources(t *testing.T) {
+	opts := getOptionsFromLabels([]string{"self-hosted", "job-manifest=job.yaml", "size=large", "memory=12Gi"})
+
+	res, err := resolveResources(opts, config.DefaultSizes)
+	if err != nil {
+		t.Fatalf("resolveResources() error = %v", err)
+	}
+	if res.cpu != "4" || res.memory != "12Gi" {
+		t.Errorf("resolveResources() = %+v, want the memory label to override the size", res)
+	}
+
+	if _, err := resolveResources(labeledOptions{size: "huge"}, config.DefaultSizes); err == nil {
+		t.Error("resolveResources() error = nil for unknown size")
+	}
+
+	// the memory label is checked with the cpu of the manifest
+	job := &run.Job{Spec: &run.JobSpec{Template: &run.ExecutionTemplateSpec{Spec: &run.ExecutionSpec{Template: &run.TaskTemplateSpec{Spec: &run.TaskSpec{
+		Containers: []*run.Container{{Resources: &run.ResourceRequirements{Limits: map[string]string{"cpu": "1"}}}},
+	}}}}}}
+	if err := applyResources(job, containerResources{memory: "8Gi"}); err == nil {
+		t.Error("applyResources() error = nil for 8Gi memory with 1 cpu")
+	}
+	if err := applyResources(job, containerResources{cpu: "2", memory: "8Gi"}); err != nil {
+		t.Errorf("applyResources() error = %v", err)
+	}
+	if limits := job.Spec.Template.Spec.Template.Spec.Containers[0].Resources.Limits; limits["cpu"] != "2" || limits["memory"] != "8Gi" {
+		t.Errorf("limits = %v, want the resources applied", limits)
+	}
+}
+
+func TestApplyResourcesWithoutLabels(t *testing.T) {
+	// the limits of the manifest are not validated without resource labels
+	limits := map[string]string{"cpu": "1000m", "memory": "4096Ki"}
+	job := &run.Job{Spec: &run.JobSpec{Template: &run.ExecutionTemplateSpec{Spec: &run.ExecutionSpec{Template: &run.TaskTemplateSpec{Spec: &run.TaskSpec{
+		Containers: []*run.Container{{Resources: &run.ResourceRequirements{Limits: limits}}},
+	}}}}}}
+	if err := applyResources(job, containerResources{}); err != nil {
+		t.Errorf("applyResources() error = %v, want the limits of the manifest left as is", err)
+	}
+	if got := job.Spec.Template.Spec.Template.Spec.Containers[0].Resources.Limits; got["cpu"] != "1000m" || got["memory"] != "4096Ki" {
+		t.Errorf("limits = %v, want the limits of the manifest", got)
+	}
+}
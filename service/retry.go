@@ -0,0 +1,58 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/karahiyo/actions-job/adapter"
+	"github.com/karahiyo/actions-job/config"
+	"github.com/rs/zerolog"
+)
+
+// retryPolicy retries transient API errors with exponential backoff and full jitter, within a time budget
+type retryPolicy struct {
+	initial time.Duration
+	max     time.Duration
+	// budget is the total time to spend on an operation including the backoff. Zero disables retries.
+	budget time.Duration
+}
+
+func newRetryPolicy(conf config.ServerConfig) retryPolicy {
+	return retryPolicy{initial: conf.RetryInitialBackoff, max: conf.RetryMaxBackoff, budget: conf.RetryBudget}
+}
+
+// do calls fn until it succeeds, returns an error which is not retryable, or the next attempt would exceed the budget
+func (p retryPolicy) do(ctx context.Context, operation string, fn func() error) error {
+	start := time.Now()
+	backoff := p.initial
+
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || !adapter.IsRetryable(err) {
+			return err
+		}
+		// the rate limit resets long after the budget, so that the callers defer the work instead
+		if errors.As(err, new(*adapter.RateLimitError)) {
+			return err
+		}
+
+		wait := time.Duration(rand.Int63n(int64(backoff))) + 1 // nolint:gosec
+		if time.Since(start)+wait > p.budget {
+			return fmt.Errorf("retry budget exhausted: operation=%s, attempts=%d, %w", operation, attempt, err)
+		}
+		zerolog.Ctx(ctx).Warn().Err(err).Msgf("retrying %s in %s: attempt=%d", operation, wait, attempt)
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(wait):
+		}
+
+		if backoff *= 2; backoff > p.max {
+			backoff = p.max
+		}
+	}
+}
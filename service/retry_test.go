@@ -0,0 +1,47 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/karahiyo/actions-job/adapter"
+)
+
+func TestRetryPolicy(t *testing.T) {
+	p := retryPolicy{initial: time.Millisecond, max: 2 * time.Millisecond, budget: time.Second}
+	transient := &adapter.APIError{Err: errors.New("503"), Kind: adapter.ErrUnavailable}
+	permanent := &adapter.APIError{Err: errors.New("403"), Kind: adapter.ErrPermissionDenied}
+
+	calls := 0
+	err := p.do(context.Background(), "test", func() error {
+		if calls++; calls < 3 {
+			return transient
+		}
+		return nil
+	})
+	if err != nil || calls != 3 {
+		t.Errorf("do() = %v after %d calls, want success after 3 calls", err, calls)
+	}
+
+	calls = 0
+	err = p.do(context.Background(), "test", func() error {
+		calls++
+		return permanent
+	})
+	if !errors.Is(err, adapter.ErrPermissionDenied) || calls != 1 {
+		t.Errorf("do() = %v after %d calls, want the permanent error without retries", err, calls)
+	}
+
+	// no retries without budget
+	calls = 0
+	p.budget = 0
+	err = p.do(context.Background(), "test", func() error {
+		calls++
+		return transient
+	})
+	if !errors.Is(err, adapter.ErrUnavailable) || calls != 1 {
+		t.Errorf("do() = %v after %d calls, want the transient error after the budget is exhausted", err, calls)
+	}
+}
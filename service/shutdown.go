@@ -0,0 +1,180 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/google/go-github/v52/github"
+	"github.com/rs/zerolog"
+)
+
+// sourcedEvent is a workflow_job event with the name of the webhook source it was received from.
+// The source is empty for the events of the GitHub App.
+type sourcedEvent struct {
+	source string
+	event  *github.WorkflowJobEvent
+}
+
+// inflightDispatches tracks the workflow_job events being dispatched, so that shutdown can wait for them
+type inflightDispatches struct {
+	events   map[int64]sourcedEvent
+	wg       sync.WaitGroup
+	mu       sync.Mutex
+	draining bool
+}
+
+// begin registers event as in flight. It fails with ErrShuttingDown once the controller started shutting down, and with
+// ErrNonTargetEvent if the workflow job is already in flight, e.g. delivered by the webhooks of several sources at once.
+func (d *inflightDispatches) begin(source string, event *github.WorkflowJobEvent) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.draining {
+		return fmt.Errorf("controller is shutting down: %w", ErrShuttingDown)
+	}
+	id := event.GetWorkflowJob().GetID()
+	if _, ok := d.events[id]; ok {
+		return fmt.Errorf("workflow job is already being dispatched: %w", ErrNonTargetEvent)
+	}
+	if d.events == nil {
+		d.events = make(map[int64]sourcedEvent)
+	}
+	d.events[id] = sourcedEvent{source: source, event: event}
+	d.wg.Add(1)
+
+	return nil
+}
+
+func (d *inflightDispatches) end(event *github.WorkflowJobEvent) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	delete(d.events, event.GetWorkflowJob().GetID())
+	d.wg.Done()
+}
+
+// has reports whether the workflow job is being dispatched
+func (d *inflightDispatches) has(id int64) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	_, ok := d.events[id]
+	return ok
+}
+
+// drain stops accepting new dispatches and waits until the in-flight dispatches finish or ctx is done.
+// It returns the events that did not finish in time.
+func (d *inflightDispatches) drain(ctx context.Context) []sourcedEvent {
+	d.mu.Lock()
+	d.draining = true
+	d.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	unfinished := make([]sourcedEvent, 0, len(d.events))
+	for _, event := range d.events {
+		unfinished = append(unfinished, event)
+	}
+
+	return unfinished
+}
+
+// Shutdown stops accepting new events and waits for in-flight dispatches until ctx is done.
+// Dispatches that did not finish and queued workflow jobs are saved to the spool directory,
+// so that they are dispatched on the next startup.
+func (c *Controller) Shutdown(ctx context.Context) error {
+	logger := zerolog.Ctx(ctx)
+
+	unfinished := c.inflight.drain(ctx)
+	unfinished = append(unfinished, c.limiter.drainQueue()...)
+	if len(unfinished) == 0 {
+		logger.Info().Msg("all in-flight dispatches finished")
+		return nil
+	}
+
+	var errs []error
+	for _, e := range unfinished {
+		if err := c.saveUnfinished(ctx, e); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%d dispatches did not finish before shutdown: %w", len(unfinished), errors.Join(errs...))
+	}
+
+	return fmt.Errorf("%d dispatches did not finish before shutdown", len(unfinished))
+}
+
+// saveUnfinished saves e to the spool directory, or logs it if the spool is disabled
+func (c *Controller) saveUnfinished(ctx context.Context, e sourcedEvent) error {
+	logger := zerolog.Ctx(ctx)
+
+	if !c.spool.enabled() {
+		logger.Error().Msgf("dispatch did not finish before shutdown and spool is disabled: repo=%s, workflow_job_id=%d",
+			e.event.GetRepo().GetFullName(), e.event.GetWorkflowJob().GetID())
+		return nil
+	}
+
+	path, err := c.spool.save(e)
+	if err != nil {
+		return err
+	}
+	logger.Warn().Msgf("dispatch did not finish before shutdown, saved to spool: path=%s", path)
+
+	return nil
+}
+
+// ReplaySpooled dispatches the events saved to the spool directory by a previous instance
+func (c *Controller) ReplaySpooled(ctx context.Context) error {
+	if !c.spool.enabled() {
+		return nil
+	}
+	logger := zerolog.Ctx(ctx)
+
+	spooled, err := c.spool.load()
+	if err != nil {
+		return fmt.Errorf("failed to load spooled events: %w", err)
+	}
+
+	for _, s := range spooled {
+		// another instance replaying the spool at the same time claimed the file first
+		claimed, err := c.spool.claim(s.path)
+		if err != nil {
+			logger.Info().Err(err).Msgf("skipped spooled event claimed by another instance: path=%s", s.path)
+			continue
+		}
+
+		err = c.ReceiveWorkflowJobEvent(ctx, s.source, s.event)
+		if errors.Is(err, ErrShuttingDown) {
+			// leave the event to the next instance
+			return c.spool.unclaim(claimed, s.path)
+		}
+		// the event is saved again by Shutdown if it is still in flight or queued
+		if err := c.spool.remove(claimed); err != nil {
+			return err
+		}
+		if err != nil {
+			logger.Error().Err(err).Msgf("failed to dispatch spooled event: path=%s", s.path)
+			continue
+		}
+		logger.Info().Msgf("dispatched spooled event: path=%s", s.path)
+	}
+
+	return nil
+}
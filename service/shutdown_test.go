@@ -0,0 +1,91 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v52/github"
+)
+
+func newTestEvent(id int64) *github.WorkflowJobEvent {
+	return &github.WorkflowJobEvent{
+		Action:      github.String("queued"),
+		WorkflowJob: &github.WorkflowJob{ID: github.Int64(id)},
+		Repo:        &github.Repository{FullName: github.String("owner/repo")},
+	}
+}
+
+func TestInflightDispatches_Drain(t *testing.T) {
+	var d inflightDispatches
+	finished := newTestEvent(1)
+	stuck := newTestEvent(2)
+
+	if d.begin("", finished) != nil || d.begin("", stuck) != nil {
+		t.Fatal("begin() failed before drain")
+	}
+	if err := d.begin("other", stuck); !errors.Is(err, ErrNonTargetEvent) {
+		t.Errorf("begin() error = %v for the workflow job in flight, want ErrNonTargetEvent", err)
+	}
+	d.end(finished)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	unfinished := d.drain(ctx)
+	if len(unfinished) != 1 || unfinished[0].event.GetWorkflowJob().GetID() != 2 {
+		t.Errorf("drain() = %v, want the stuck event", unfinished)
+	}
+	if err := d.begin("", newTestEvent(3)); !errors.Is(err, ErrShuttingDown) {
+		t.Errorf("begin() error = %v after drain, want ErrShuttingDown", err)
+	}
+}
+
+func TestController_ShutdownSpoolsUnfinished(t *testing.T) {
+	c := &Controller{spool: newSpool(t.TempDir()), limiter: newConcurrencyLimiter()}
+	c.inflight.begin("repo", newTestEvent(42))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if err := c.Shutdown(ctx); err == nil {
+		t.Fatal("Shutdown() error = nil, want unfinished dispatch error")
+	}
+
+	spooled, err := c.spool.load()
+	if err != nil {
+		t.Fatalf("load() error = %v", err)
+	}
+	if len(spooled) != 1 || spooled[0].event.GetWorkflowJob().GetID() != 42 || spooled[0].source != "repo" {
+		t.Errorf("load() = %v, want the unfinished event with its source", spooled)
+	}
+}
+
+func TestSpool_Claim(t *testing.T) {
+	dir := t.TempDir()
+	s, other := newSpool(dir), newSpool(dir)
+	path, err := s.save(sourcedEvent{event: newTestEvent(42)})
+	if err != nil {
+		t.Fatalf("save() error = %v", err)
+	}
+
+	claimed, err := s.claim(path)
+	if err != nil {
+		t.Fatalf("claim() error = %v", err)
+	}
+	// the claimed file is neither loaded nor claimed by the other instances
+	if _, err := other.claim(path); err == nil {
+		t.Error("claim() error = nil for the file claimed by another instance")
+	}
+	if spooled, err := other.load(); err != nil || len(spooled) != 0 {
+		t.Errorf("load() = %v, %v, want no events", spooled, err)
+	}
+
+	if err := s.unclaim(claimed, path); err != nil {
+		t.Fatalf("unclaim() error = %v", err)
+	}
+	if spooled, err := other.load(); err != nil || len(spooled) != 1 {
+		t.Errorf("load() = %v, %v, want the unclaimed event", spooled, err)
+	}
+}
@@ -0,0 +1,118 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/google/go-github/v52/github"
+)
+
+// spool persists workflow_job events that could not be dispatched before shutdown,
+// so that the next instance can dispatch them.
+type spool struct {
+	dir string
+	// instance names the spool files claimed by this instance
+	instance string
+}
+
+type spooledEvent struct {
+	sourcedEvent
+	path string
+}
+
+// spoolFile is the format of the spool files. Files saved before webhook sources were supported only have the event.
+type spoolFile struct {
+	Source string                   `json:"source,omitempty"`
+	Event  *github.WorkflowJobEvent `json:"event"`
+}
+
+func newSpool(dir string) *spool {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+
+	return &spool{dir: dir, instance: hex.EncodeToString(b)}
+}
+
+func (s *spool) enabled() bool {
+	return s != nil && s.dir != ""
+}
+
+func (s *spool) save(e sourcedEvent) (string, error) {
+	if err := os.MkdirAll(s.dir, 0o700); err != nil {
+		return "", fmt.Errorf("failed to create spool directory: dir=%s, %w", s.dir, err)
+	}
+
+	b, err := json.Marshal(spoolFile{Source: e.source, Event: e.event})
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal event: %w", err)
+	}
+
+	path := filepath.Join(s.dir, strconv.FormatInt(e.event.GetWorkflowJob().GetID(), 10)+".json")
+	if err := os.WriteFile(path, b, 0o600); err != nil {
+		return "", fmt.Errorf("failed to write spool file: path=%s, %w", path, err)
+	}
+
+	return path, nil
+}
+
+func (s *spool) load() ([]spooledEvent, error) {
+	paths, err := filepath.Glob(filepath.Join(s.dir, "*.json"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list spool files: dir=%s, %w", s.dir, err)
+	}
+
+	events := make([]spooledEvent, 0, len(paths))
+	for _, path := range paths {
+		b, err := os.ReadFile(path) // nolint:gosec
+		if err != nil {
+			return nil, fmt.Errorf("failed to read spool file: path=%s, %w", path, err)
+		}
+
+		var f spoolFile
+		if err := json.Unmarshal(b, &f); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal spool file: path=%s, %w", path, err)
+		}
+		if f.Event == nil {
+			f.Event = new(github.WorkflowJobEvent)
+			if err := json.Unmarshal(b, f.Event); err != nil {
+				return nil, fmt.Errorf("failed to unmarshal spool file: path=%s, %w", path, err)
+			}
+		}
+		events = append(events, spooledEvent{sourcedEvent: sourcedEvent{source: f.Source, event: f.Event}, path: path})
+	}
+
+	return events, nil
+}
+
+// claim renames the spool file at path to a name of this instance, which is not loaded by the other instances.
+// It fails if the file was claimed by another instance.
+func (s *spool) claim(path string) (string, error) {
+	claimed := path + "." + s.instance + ".claimed"
+	if err := os.Rename(path, claimed); err != nil {
+		return "", fmt.Errorf("failed to claim spool file: path=%s, %w", path, err)
+	}
+
+	return claimed, nil
+}
+
+// unclaim renames the spool file claimed by claim back to path, so that the next instance loads it
+func (s *spool) unclaim(claimed, path string) error {
+	if err := os.Rename(claimed, path); err != nil {
+		return fmt.Errorf("failed to unclaim spool file: path=%s, %w", claimed, err)
+	}
+
+	return nil
+}
+
+func (s *spool) remove(path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove spool file: path=%s, %w", path, err)
+	}
+
+	return nil
+}
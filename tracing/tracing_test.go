@@ -0,0 +1,67 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestNewExporter(t *testing.T) {
+	tests := []struct {
+		name    string
+		wantNil bool
+		wantErr bool
+	}{
+		{name: "", wantNil: true},
+		{name: ExporterNone, wantNil: true},
+		{name: ExporterStdout},
+		{name: "unknown", wantNil: true, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewExporter(context.Background(), tt.name, &bytes.Buffer{})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewExporter() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if (got == nil) != tt.wantNil {
+				t.Errorf("NewExporter() = %v, wantNil %v", got, tt.wantNil)
+			}
+		})
+	}
+}
+
+func TestEnd(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	otel.SetTracerProvider(tp)
+	t.Cleanup(func() { _ = tp.Shutdown(context.Background()) })
+
+	_, span := Start(context.Background(), "ok", AttrRepository.String("owner/repo"))
+	End(span, nil)
+	_, span = Start(context.Background(), "failed")
+	End(span, errors.New("boom"))
+
+	spans := exporter.GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans, want 2", len(spans))
+	}
+	if got := spans[0].Status.Code; got != codes.Unset {
+		t.Errorf("span %q status = %v, want %v", spans[0].Name, got, codes.Unset)
+	}
+	if got := spans[0].Attributes[0]; got != AttrRepository.String("owner/repo") {
+		t.Errorf("span %q attribute = %v", spans[0].Name, got)
+	}
+	if got := spans[1].Status.Code; got != codes.Error {
+		t.Errorf("span %q status = %v, want %v", spans[1].Name, got, codes.Error)
+	}
+	if len(spans[1].Events) != 1 {
+		t.Errorf("span %q has %d events, want recorded error", spans[1].Name, len(spans[1].Events))
+	}
+}